@@ -0,0 +1,172 @@
+package dedup
+
+import (
+	"context"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+)
+
+// IsDuplicateBatch is IsDuplicate for many entities in one storage round trip.
+// Results and errors are returned per entity, in input order; an entity whose
+// error is set has no meaningful result. When storeIfNot is given, new entities
+// are stored and repeats of an entity later in the same batch are duplicates.
+func (d *Deduper) IsDuplicateBatch(ctx context.Context, entities []any, strategy HashStrategy, storeIfNot ...time.Duration) ([]bool, []error) {
+	results := make([]bool, len(entities))
+	errs := make([]error, len(entities))
+	d.count(ctx, OpIsDuplicateBatch, MetricCheck, len(entities))
+
+	hashes, keys, indexes := d.batchKeys(ctx, entities, strategy, errs)
+	if len(keys) == 0 {
+		return results, errs
+	}
+
+	exists, err := ExistsBatch(ctx, d.storage, keys)
+	if err != nil {
+		err = errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
+	} else {
+		err = d.legacyExistsBatch(ctx, hashes, exists)
+	}
+	if err != nil {
+		d.count(ctx, OpIsDuplicateBatch, MetricStorageError, 1)
+		for _, i := range indexes {
+			errs[i] = err
+		}
+		return results, errs
+	}
+
+	for n, i := range indexes {
+		results[i] = exists[n]
+		if results[i] || !d.migrating() {
+			continue
+		}
+
+		previous, err := d.migrated(ctx, entities[i], keys[n])
+		if err != nil {
+			d.fail(ctx, OpIsDuplicateBatch, err)
+			errs[i] = err
+			continue
+		}
+		results[i] = previous != nil
+	}
+
+	if len(storeIfNot) == 0 {
+		d.count(ctx, OpIsDuplicateBatch, MetricDuplicate, countTrue(results))
+		return results, errs
+	}
+
+	var storeKeys, storeValues [][]byte
+	seen := make(map[string]struct{}, len(keys))
+	for n, i := range indexes {
+		if errs[i] != nil {
+			continue
+		}
+		if _, ok := seen[string(keys[n])]; ok {
+			results[i] = true
+			continue
+		}
+		seen[string(keys[n])] = struct{}{}
+
+		if results[i] {
+			continue
+		}
+
+		value, err := d.value(ctx, entities[i], strategy)
+		if err != nil {
+			d.fail(ctx, OpIsDuplicateBatch, err)
+			d.logger.With("error", err).Error("failed to serialize entity at IsDuplicateBatch; %s", err.Error())
+			continue
+		}
+		storeKeys = append(storeKeys, keys[n])
+		storeValues = append(storeValues, value)
+	}
+
+	d.count(ctx, OpIsDuplicateBatch, MetricDuplicate, countTrue(results))
+
+	if len(storeKeys) > 0 {
+		err = SetEXBatch(ctx, d.storage, storeKeys, storeValues, storeIfNot[0])
+		if err != nil {
+			d.count(ctx, OpIsDuplicateBatch, MetricStorageError, 1)
+			d.logger.With("error", err).Error("failed to store hashes at IsDuplicateBatch; %s", err.Error())
+		} else {
+			d.count(ctx, OpIsDuplicateBatch, MetricStore, len(storeKeys))
+		}
+	}
+
+	return results, errs
+}
+
+// StoreBatch is Store for many entities in one storage round trip.
+// Hashes and errors are returned per entity, in input order.
+func (d *Deduper) StoreBatch(ctx context.Context, entities []any, strategy HashStrategy, expiration time.Duration) ([][]byte, []error) {
+	hashes := make([][]byte, len(entities))
+	errs := make([]error, len(entities))
+
+	entityHashes, keys, indexes := d.batchKeys(ctx, entities, strategy, errs)
+
+	var storeHashes, storeKeys, storeValues [][]byte
+	var storeIndexes []int
+	for n, i := range indexes {
+		value, err := d.value(ctx, entities[i], strategy)
+		if err != nil {
+			d.fail(ctx, OpStoreBatch, err)
+			errs[i] = err
+			continue
+		}
+		storeHashes = append(storeHashes, entityHashes[n])
+		storeKeys = append(storeKeys, keys[n])
+		storeValues = append(storeValues, value)
+		storeIndexes = append(storeIndexes, i)
+	}
+
+	if len(storeKeys) == 0 {
+		return hashes, errs
+	}
+
+	err := SetEXBatch(ctx, d.storage, storeKeys, storeValues, expiration)
+	if err != nil {
+		d.count(ctx, OpStoreBatch, MetricStorageError, 1)
+		err = errors.Wrap(err, "failed to store dedupHash; %s", err.Error(), DedupStorageErrorCode)
+	} else {
+		d.count(ctx, OpStoreBatch, MetricStore, len(storeKeys))
+	}
+
+	for n, i := range storeIndexes {
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		hashes[i] = storeHashes[n]
+	}
+
+	return hashes, errs
+}
+
+// batchKeys builds the hash and key of every entity, recording hashing errors in errs.
+// It returns the hashes and keys that were built and the input index each one belongs to.
+func (d *Deduper) batchKeys(ctx context.Context, entities []any, strategy HashStrategy, errs []error) ([][]byte, [][]byte, []int) {
+	hashes := make([][]byte, 0, len(entities))
+	keys := make([][]byte, 0, len(entities))
+	indexes := make([]int, 0, len(entities))
+	for i, entity := range entities {
+		dedupHash, err := d.Hash(ctx, entity, strategy, false)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		hashes = append(hashes, dedupHash)
+		keys = append(keys, d.buildKey(ctx, dedupHash))
+		indexes = append(indexes, i)
+	}
+	return hashes, keys, indexes
+}
+
+func countTrue(values []bool) int {
+	n := 0
+	for _, v := range values {
+		if v {
+			n++
+		}
+	}
+	return n
+}
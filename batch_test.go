@@ -0,0 +1,179 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha1"
+	"hash"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduperBatch(t *testing.T) {
+	// Setup miniredis
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	storage := NewRedisStorage(ctx, client)
+
+	hashHandler := func(ctx context.Context, entity TestEntity) ([]byte, error) {
+		return []byte(entity.ID + ":" + entity.Name), nil
+	}
+
+	serializer := func(ctx context.Context, inputEntity any) (string, error) {
+		entity := inputEntity.(TestEntity)
+		return entity.ID + "|" + entity.Name, nil
+	}
+
+	t.Run("RedisStorage batch calls", func(t *testing.T) {
+		mr.FlushAll()
+
+		err := storage.SetEXBatch(ctx, [][]byte{[]byte("a"), []byte("b")}, [][]byte{[]byte("1"), []byte("2")}, 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, mr.TTL("a") > 0)
+
+		val, err := mr.Get("b")
+		assert.NoError(t, err)
+		assert.Equal(t, "2", val)
+
+		exists, err := storage.ExistsBatch(ctx, [][]byte{[]byte("a"), []byte("missing"), []byte("b")})
+		assert.NoError(t, err)
+		assert.Equal(t, []bool{true, false, true}, exists)
+	})
+
+	t.Run("IsDuplicateBatch with per-entity results and errors", func(t *testing.T) {
+		mr.FlushAll()
+
+		deduper := NewDeduper(hashHandler, storage, NewMockLogger(), func() hash.Hash { return sha1.New() }, nil, serializer)
+
+		stored := TestEntity{ID: "1", Name: "stored"}
+		_, _, err := deduper.Store(ctx, stored, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+
+		entities := []any{
+			TestEntity{ID: "2", Name: "new"},
+			nil,
+			stored,
+			"not a TestEntity",
+			TestEntity{ID: "2", Name: "new"},
+		}
+
+		results, errs := deduper.IsDuplicateBatch(ctx, entities, DefaultHashStrategy(), 10*time.Second)
+		assert.Len(t, results, len(entities))
+		assert.Len(t, errs, len(entities))
+
+		assert.NoError(t, errs[0])
+		assert.False(t, results[0])
+
+		assert.Error(t, errs[1])
+		assert.Contains(t, errs[1].Error(), "entity is nil")
+
+		assert.NoError(t, errs[2])
+		assert.True(t, results[2])
+
+		assert.Error(t, errs[3])
+		assert.Contains(t, errs[3].Error(), "entity is not of type")
+
+		// A repeat inside the same batch is a duplicate of the first occurrence
+		assert.NoError(t, errs[4])
+		assert.True(t, results[4])
+
+		// New entities were stored
+		isDuplicate, err := deduper.IsDuplicate(ctx, TestEntity{ID: "2", Name: "new"}, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+	})
+
+	t.Run("IsDuplicateBatch without storeIfNot only checks", func(t *testing.T) {
+		mr.FlushAll()
+
+		deduper := NewDeduper(hashHandler, storage, NewMockLogger(), func() hash.Hash { return sha1.New() }, nil, serializer)
+		entity := TestEntity{ID: "1", Name: "new"}
+
+		results, errs := deduper.IsDuplicateBatch(ctx, []any{entity, entity}, DefaultHashStrategy())
+		assert.Equal(t, []bool{false, false}, results)
+		assert.Equal(t, []error{nil, nil}, errs)
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, entity, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+
+	t.Run("StoreBatch", func(t *testing.T) {
+		mr.FlushAll()
+
+		deduper := NewDeduper(hashHandler, storage, NewMockLogger(), func() hash.Hash { return sha1.New() }, nil, serializer)
+		entities := []any{TestEntity{ID: "1", Name: "a"}, nil, TestEntity{ID: "2", Name: "b"}}
+
+		hashes, errs := deduper.StoreBatch(ctx, entities, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, errs[0])
+		assert.Error(t, errs[1])
+		assert.NoError(t, errs[2])
+		assert.Equal(t, []byte("1:a"), hashes[0])
+		assert.Nil(t, hashes[1])
+		assert.Equal(t, []byte("2:b"), hashes[2])
+
+		ttl, err := deduper.TTL(ctx, hashes[2])
+		assert.NoError(t, err)
+		assert.True(t, ttl > 0 && ttl <= 10*time.Second)
+
+		val, err := mr.Get("dedup:dedup.TestEntity:1:a")
+		assert.NoError(t, err)
+		assert.Equal(t, "1|a", val)
+	})
+
+	t.Run("Storage error is reported for every hashed entity", func(t *testing.T) {
+		mockStorage := &MockStorage{
+			existsFunc: func(ctx context.Context, key []byte) (bool, error) {
+				return false, assert.AnError
+			},
+			setExFunc: func(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error {
+				return assert.AnError
+			},
+		}
+
+		deduper := NewDeduper(hashHandler, mockStorage, NewMockLogger(), func() hash.Hash { return sha1.New() }, nil, serializer)
+		entities := []any{TestEntity{ID: "1", Name: "a"}, nil}
+
+		_, errs := deduper.IsDuplicateBatch(ctx, entities, DefaultHashStrategy())
+		assert.Contains(t, errs[0].Error(), "storage error")
+		assert.Contains(t, errs[1].Error(), "entity is nil")
+
+		_, errs = deduper.StoreBatch(ctx, entities, DefaultHashStrategy(), time.Second)
+		assert.Contains(t, errs[0].Error(), "failed to store")
+		assert.Contains(t, errs[1].Error(), "entity is nil")
+	})
+
+	t.Run("Fallback without BatchStorage", func(t *testing.T) {
+		memory := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		calls := 0
+		mockStorage := &MockStorage{
+			existsFunc: func(ctx context.Context, key []byte) (bool, error) {
+				calls++
+				return memory.Exists(ctx, key)
+			},
+			setExFunc: func(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error {
+				calls++
+				return memory.SetEX(ctx, key, value, expiration...)
+			},
+		}
+
+		deduper := NewTypedDeduper(hashHandler, mockStorage, NewMockLogger(), func() hash.Hash { return sha1.New() }, nil, nil)
+		entities := []TestEntity{{ID: "1", Name: "a"}, {ID: "2", Name: "b"}}
+
+		results, errs := deduper.IsDuplicateBatch(ctx, entities, DefaultHashStrategy())
+		assert.Equal(t, []bool{false, false}, results)
+		assert.Equal(t, []error{nil, nil}, errs)
+		assert.Equal(t, 2, calls)
+	})
+}
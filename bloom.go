@@ -0,0 +1,349 @@
+package dedup
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// BloomFilter is a probabilistic set of keys placed in front of a Storage by BloomStorage.
+// Test must never report false for a key added within the last Window; false positives are allowed.
+type BloomFilter interface {
+	// Add records key in the filter
+	Add(ctx context.Context, key []byte) error
+	// Test reports whether key may have been added; false means it was definitely not added within Window
+	Test(ctx context.Context, key []byte) (bool, error)
+	// Window is the minimum time a key is remembered after Add
+	Window() time.Duration
+}
+
+// BloomFilterConfig sizes and ages a LocalBloomFilter
+type BloomFilterConfig struct {
+	// ExpectedItems is the number of keys added per Window, defaults to 1,000,000
+	ExpectedItems uint
+	// FalsePositiveRate is the target false positive probability at ExpectedItems, defaults to 0.01
+	FalsePositiveRate float64
+	// Window is how long a key is remembered at least; it should cover the longest TTL
+	// passed to Store. Keys are forgotten between one and two windows after Add. Defaults to DefaultTTL.
+	Window time.Duration
+	// Now returns the current time, defaults to time.Now; inject a fake clock for deterministic rotation
+	Now func() time.Time
+}
+
+// LocalBloomFilter is an in-process BloomFilter aged out by rotation: keys go into the current
+// generation and lookups check the current and the previous one. Every Window the previous
+// generation is dropped and the current one takes its place.
+// It only sees keys added in this process, so every writer of the storage must go through it.
+type LocalBloomFilter struct {
+	mu       sync.Mutex
+	bits     uint64 // bits per generation
+	hashes   uint64
+	window   time.Duration
+	now      func() time.Time
+	epoch    int64 // window number of current
+	current  []uint64
+	previous []uint64
+}
+
+// NewLocalBloomFilter creates a LocalBloomFilter sized for the expected cardinality and false positive rate
+func NewLocalBloomFilter(config ...BloomFilterConfig) *LocalBloomFilter {
+	var cfg BloomFilterConfig
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if cfg.ExpectedItems == 0 {
+		cfg.ExpectedItems = 1_000_000
+	}
+	if cfg.FalsePositiveRate <= 0 || cfg.FalsePositiveRate >= 1 {
+		cfg.FalsePositiveRate = 0.01
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultTTL
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	bits, hashes := bloomSize(cfg.ExpectedItems, cfg.FalsePositiveRate)
+	return &LocalBloomFilter{
+		bits:     bits,
+		hashes:   hashes,
+		window:   cfg.Window,
+		now:      cfg.Now,
+		epoch:    cfg.Now().UnixNano() / int64(cfg.Window),
+		current:  make([]uint64, (bits+63)/64),
+		previous: make([]uint64, (bits+63)/64),
+	}
+}
+
+// bloomSize returns the optimal bit count m = -n ln p / ln2^2 and hash count k = m/n ln2
+func bloomSize(n uint, p float64) (uint64, uint64) {
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	return uint64(math.Max(m, 64)), uint64(math.Max(k, 1))
+}
+
+// Add records key in the current generation
+func (f *LocalBloomFilter) Add(_ context.Context, key []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rotate()
+	h1, h2 := bloomHash(key)
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % f.bits
+		f.current[bit/64] |= 1 << (bit % 64)
+	}
+	return nil
+}
+
+// Test reports whether key may be in the current or the previous generation
+func (f *LocalBloomFilter) Test(_ context.Context, key []byte) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rotate()
+	h1, h2 := bloomHash(key)
+	return f.contains(f.current, h1, h2) || f.contains(f.previous, h1, h2), nil
+}
+
+// Window returns the rotation interval
+func (f *LocalBloomFilter) Window() time.Duration {
+	return f.window
+}
+
+func (f *LocalBloomFilter) contains(generation []uint64, h1 uint64, h2 uint64) bool {
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % f.bits
+		if generation[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// rotate moves to the window of the current time; callers must hold the lock
+func (f *LocalBloomFilter) rotate() {
+	epoch := f.now().UnixNano() / int64(f.window)
+	switch {
+	case epoch <= f.epoch:
+		return
+	case epoch == f.epoch+1:
+		f.previous, f.current = f.current, f.previous
+		clear(f.current)
+	default:
+		clear(f.current)
+		clear(f.previous)
+	}
+	f.epoch = epoch
+}
+
+// bloomHash derives the two base hashes for double hashing from a 128 bit FNV-1a
+func bloomHash(key []byte) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write(key)
+	sum := h.Sum(nil)
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:]) | 1
+}
+
+// BloomStorage puts a BloomFilter in front of a Storage: a negative filter answer skips the
+// storage lookup, a positive one falls through to the storage, which stays authoritative.
+// Writes are recorded in the filter. While a key written with an expiration longer than the
+// filter Window, or without one, may still be alive, negative answers are not trusted.
+type BloomStorage struct {
+	storage Storage
+	filter  BloomFilter
+	now     func() time.Time
+
+	mu         sync.Mutex
+	trustAfter time.Time
+}
+
+// NewBloomStorage wraps storage with filter; optional capabilities of storage are kept.
+// Keys written before it was created are unknown to the filter, so negative answers
+// are only trusted once the filter Window has passed.
+func NewBloomStorage(storage Storage, filter BloomFilter) *BloomStorage {
+	return &BloomStorage{storage: storage, filter: filter, now: time.Now, trustAfter: time.Now().Add(filter.Window())}
+}
+
+// mayExist reports whether the storage must be asked about key
+func (b *BloomStorage) mayExist(ctx context.Context, key []byte) (bool, error) {
+	b.mu.Lock()
+	trusted := !b.now().Before(b.trustAfter)
+	b.mu.Unlock()
+	if !trusted {
+		return true, nil
+	}
+	return b.filter.Test(ctx, key)
+}
+
+// added records keys written with expiration
+func (b *BloomStorage) added(ctx context.Context, expiration time.Duration, keys ...[]byte) error {
+	if expiration <= 0 || expiration > b.filter.Window() {
+		until := time.Unix(0, math.MaxInt64)
+		if expiration > 0 {
+			until = b.now().Add(expiration)
+		}
+
+		b.mu.Lock()
+		if until.After(b.trustAfter) {
+			b.trustAfter = until
+		}
+		b.mu.Unlock()
+	}
+
+	for _, key := range keys {
+		err := b.filter.Add(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get retrieves a value, skipping the storage when the filter rules the key out
+func (b *BloomStorage) Get(ctx context.Context, key []byte) ([]byte, error) {
+	ok, err := b.mayExist(ctx, key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return b.storage.Get(ctx, key)
+}
+
+// Exists checks a key, skipping the storage when the filter rules the key out
+func (b *BloomStorage) Exists(ctx context.Context, key []byte) (bool, error) {
+	ok, err := b.mayExist(ctx, key)
+	if err != nil || !ok {
+		return false, err
+	}
+	return b.storage.Exists(ctx, key)
+}
+
+// TTL retrieves the time-to-live of a key, -2 when the filter rules the key out
+func (b *BloomStorage) TTL(ctx context.Context, key []byte) (time.Duration, error) {
+	ok, err := b.mayExist(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return -2, nil
+	}
+	return b.storage.TTL(ctx, key)
+}
+
+// Del removes a key from the storage; the filter keeps it until it rotates out
+func (b *BloomStorage) Del(ctx context.Context, key []byte) (bool, error) {
+	return b.storage.Del(ctx, key)
+}
+
+// SetEX stores a value and records the key in the filter
+func (b *BloomStorage) SetEX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error {
+	err := b.storage.SetEX(ctx, key, value, expiration...)
+	if err != nil {
+		return err
+	}
+	return b.added(ctx, expirationOf(expiration), key)
+}
+
+// SetNX claims a key in the storage and records it in the filter.
+// When the storage has no atomic SetNX, a negative filter answer skips its Exists check.
+func (b *BloomStorage) SetNX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) (bool, error) {
+	if _, ok := b.storage.(AtomicStorage); !ok {
+		mayExist, err := b.mayExist(ctx, key)
+		if err != nil {
+			return false, err
+		}
+		if !mayExist {
+			return true, b.SetEX(ctx, key, value, expirationOf(expiration))
+		}
+	}
+
+	ok, err := SetNX(ctx, b.storage, key, value, expirationOf(expiration))
+	if err != nil {
+		return false, err
+	}
+	return ok, b.added(ctx, expirationOf(expiration), key)
+}
+
+// Expire refreshes the expiration of a key and records it in the filter again;
+// a non-positive expiration removes the key like Redis does
+func (b *BloomStorage) Expire(ctx context.Context, key []byte, expiration time.Duration) (bool, error) {
+	ok, err := Expire(ctx, b.storage, key, expiration)
+	if err != nil || !ok || expiration <= 0 {
+		return ok, err
+	}
+	return ok, b.added(ctx, expiration, key)
+}
+
+// CompareAndSwap compares and replaces a value in the storage and records the key in the filter
+func (b *BloomStorage) CompareAndSwap(ctx context.Context, key []byte, value []byte, replace bool, expiration ...time.Duration) (bool, bool, error) {
+	matched, replaced, err := CompareAndSwap(ctx, b.storage, key, value, replace, expirationOf(expiration))
+	if err != nil {
+		return false, false, err
+	}
+	if replaced {
+		err = b.added(ctx, expirationOf(expiration), key)
+	}
+	return matched, replaced, err
+}
+
+// Incr increments a counter in the storage and records the key in the filter
+func (b *BloomStorage) Incr(ctx context.Context, key []byte, expiration time.Duration) (int64, time.Duration, error) {
+	count, ttl, err := Incr(ctx, b.storage, key, expiration)
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, ttl, b.added(ctx, expiration, key)
+}
+
+// Scan enumerates the keys of the storage, the filter is left alone
+func (b *BloomStorage) Scan(ctx context.Context, prefix []byte, fn func(key []byte) error) error {
+	return Scan(ctx, b.storage, prefix, fn)
+}
+
+// Purge deletes the keys under prefix from the storage; the filter keeps them until they rotate out
+func (b *BloomStorage) Purge(ctx context.Context, prefix []byte) (int64, error) {
+	return Purge(ctx, b.storage, prefix)
+}
+
+// ExistsBatch checks many keys, asking the storage only about those the filter cannot rule out
+func (b *BloomStorage) ExistsBatch(ctx context.Context, keys [][]byte) ([]bool, error) {
+	exists := make([]bool, len(keys))
+
+	var candidates [][]byte
+	var indexes []int
+	for i, key := range keys {
+		ok, err := b.mayExist(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			candidates = append(candidates, key)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return exists, nil
+	}
+
+	found, err := ExistsBatch(ctx, b.storage, candidates)
+	if err != nil {
+		return nil, err
+	}
+	for i, idx := range indexes {
+		exists[idx] = found[i]
+	}
+	return exists, nil
+}
+
+// SetEXBatch stores many values and records their keys in the filter
+func (b *BloomStorage) SetEXBatch(ctx context.Context, keys [][]byte, values [][]byte, expiration ...time.Duration) error {
+	err := SetEXBatch(ctx, b.storage, keys, values, expirationOf(expiration))
+	if err != nil {
+		return err
+	}
+	return b.added(ctx, expirationOf(expiration), keys...)
+}
@@ -0,0 +1,203 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStorage counts the lookups reaching the wrapped Storage; it hides optional capabilities
+type countingStorage struct {
+	Storage
+	lookups atomic.Int32
+}
+
+func (c *countingStorage) Exists(ctx context.Context, key []byte) (bool, error) {
+	c.lookups.Add(1)
+	return c.Storage.Exists(ctx, key)
+}
+
+func (c *countingStorage) Get(ctx context.Context, key []byte) ([]byte, error) {
+	c.lookups.Add(1)
+	return c.Storage.Get(ctx, key)
+}
+
+// newTrustedBloomStorage skips the warm-up window so negative answers are trusted right away
+func newTrustedBloomStorage(storage Storage, filter BloomFilter, clock *fakeClock) *BloomStorage {
+	b := NewBloomStorage(storage, filter)
+	b.now = clock.Now
+	b.trustAfter = clock.Now()
+	return b
+}
+
+func TestLocalBloomFilter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Sizing", func(t *testing.T) {
+		bits, hashes := bloomSize(1_000_000, 0.01)
+		assert.Equal(t, uint64(9585059), bits)
+		assert.Equal(t, uint64(7), hashes)
+	})
+
+	t.Run("No false negatives and bounded false positives", func(t *testing.T) {
+		filter := NewLocalBloomFilter(BloomFilterConfig{ExpectedItems: 10_000, FalsePositiveRate: 0.01})
+
+		for i := 0; i < 10_000; i++ {
+			assert.NoError(t, filter.Add(ctx, []byte(fmt.Sprintf("added-%d", i))))
+		}
+		for i := 0; i < 10_000; i++ {
+			ok, err := filter.Test(ctx, []byte(fmt.Sprintf("added-%d", i)))
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		}
+
+		positives := 0
+		for i := 0; i < 10_000; i++ {
+			ok, _ := filter.Test(ctx, []byte(fmt.Sprintf("unseen-%d", i)))
+			if ok {
+				positives++
+			}
+		}
+		assert.Less(t, positives, 200, "false positive rate should stay near 1%")
+	})
+
+	t.Run("Rotation ages keys out", func(t *testing.T) {
+		clock := newFakeClock()
+		filter := NewLocalBloomFilter(BloomFilterConfig{ExpectedItems: 100, Window: time.Minute, Now: clock.Now})
+		assert.Equal(t, time.Minute, filter.Window())
+
+		assert.NoError(t, filter.Add(ctx, []byte("key")))
+
+		// Still remembered one window later, from the previous generation
+		clock.Advance(time.Minute)
+		ok, _ := filter.Test(ctx, []byte("key"))
+		assert.True(t, ok)
+
+		// Dropped after the second rotation
+		clock.Advance(time.Minute)
+		ok, _ = filter.Test(ctx, []byte("key"))
+		assert.False(t, ok)
+
+		// A long gap clears both generations at once
+		assert.NoError(t, filter.Add(ctx, []byte("key")))
+		clock.Advance(5 * time.Minute)
+		ok, _ = filter.Test(ctx, []byte("key"))
+		assert.False(t, ok)
+	})
+}
+
+func TestBloomStorage(t *testing.T) {
+	ctx := context.Background()
+	handler := func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }
+
+	setup := func() (*countingStorage, *BloomStorage, *fakeClock) {
+		clock := newFakeClock()
+		inner := &countingStorage{Storage: NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})}
+		filter := NewLocalBloomFilter(BloomFilterConfig{ExpectedItems: 1000, Window: time.Hour, Now: clock.Now})
+		return inner, newTrustedBloomStorage(inner, filter, clock), clock
+	}
+
+	t.Run("Negative answers skip the storage", func(t *testing.T) {
+		inner, storage, _ := setup()
+		deduper := New(handler, storage)
+
+		for i := 0; i < 10; i++ {
+			isDuplicate, err := deduper.IsDuplicate(ctx, fmt.Sprintf("order-%d", i), DefaultHashStrategy())
+			assert.NoError(t, err)
+			assert.False(t, isDuplicate)
+		}
+		assert.Equal(t, int32(0), inner.lookups.Load())
+
+		ttl, err := storage.TTL(ctx, []byte("missing"))
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(-2), ttl)
+
+		val, err := storage.Get(ctx, []byte("missing"))
+		assert.NoError(t, err)
+		assert.Nil(t, val)
+	})
+
+	t.Run("Positive answers fall through to the storage", func(t *testing.T) {
+		inner, storage, _ := setup()
+		deduper := New(handler, storage)
+
+		_, _, err := deduper.Store(ctx, "order-1", DefaultHashStrategy(), 10*time.Minute)
+		assert.NoError(t, err)
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, "order-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+		assert.Equal(t, int32(1), inner.lookups.Load())
+
+		// A deleted key stays in the filter, the storage answers
+		assert.NoError(t, deduper.Forget(ctx, "order-1", DefaultHashStrategy()))
+		isDuplicate, err = deduper.IsDuplicate(ctx, "order-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+		assert.Equal(t, int32(2), inner.lookups.Load())
+	})
+
+	t.Run("Store if not skips the existence check of new keys", func(t *testing.T) {
+		inner, storage, _ := setup()
+		deduper := New(handler, storage)
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, "order-1", DefaultHashStrategy(), 10*time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+		assert.Equal(t, int32(0), inner.lookups.Load())
+
+		isDuplicate, err = deduper.IsDuplicate(ctx, "order-1", DefaultHashStrategy(), 10*time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+	})
+
+	t.Run("Batch checks only candidates", func(t *testing.T) {
+		inner, storage, _ := setup()
+		deduper := New(handler, storage)
+
+		_, errs := deduper.StoreBatch(ctx, []any{"order-1"}, DefaultHashStrategy(), 10*time.Minute)
+		assert.Equal(t, []error{nil}, errs)
+
+		dups, errs := deduper.IsDuplicateBatch(ctx, []any{"order-1", "order-2", "order-3"}, DefaultHashStrategy())
+		assert.Equal(t, []bool{true, false, false}, dups)
+		assert.Equal(t, []error{nil, nil, nil}, errs)
+		assert.Equal(t, int32(1), inner.lookups.Load())
+	})
+
+	t.Run("Expirations longer than the window disable negatives until they pass", func(t *testing.T) {
+		inner, storage, clock := setup()
+		deduper := New(handler, storage)
+
+		_, _, err := deduper.Store(ctx, "order-1", DefaultHashStrategy(), 3*time.Hour)
+		assert.NoError(t, err)
+
+		// Two rotations later the filter forgot the key but the storage still has it
+		clock.Advance(2 * time.Hour)
+		isDuplicate, err := deduper.IsDuplicate(ctx, "order-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+		assert.Equal(t, int32(1), inner.lookups.Load())
+
+		// Trusted again once the long-lived key expired
+		clock.Advance(time.Hour)
+		isDuplicate, err = deduper.IsDuplicate(ctx, "order-2", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+		assert.Equal(t, int32(1), inner.lookups.Load())
+	})
+
+	t.Run("Negatives are not trusted during warm-up", func(t *testing.T) {
+		inner := &countingStorage{Storage: NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})}
+		assert.NoError(t, inner.SetEX(ctx, []byte("written-before"), []byte("1"), time.Minute))
+
+		storage := NewBloomStorage(inner, NewLocalBloomFilter(BloomFilterConfig{ExpectedItems: 1000}))
+		exists, err := storage.Exists(ctx, []byte("written-before"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, int32(1), inner.lookups.Load())
+	})
+}
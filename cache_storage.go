@@ -0,0 +1,283 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCacheMaxTTL is how long CacheStorage keeps a key locally unless configured otherwise
+const DefaultCacheMaxTTL = 5 * time.Second
+
+// CacheStorageConfig configures a CacheStorage
+type CacheStorageConfig struct {
+	// MaxSize caps the number of locally cached keys, evicting the least recently used one first; defaults to 10,000
+	MaxSize int
+	// MaxTTL caps how long a key is cached locally, bounding staleness after deletes made
+	// by other processes; defaults to DefaultCacheMaxTTL, negative caches for the full remaining remote TTL
+	MaxTTL time.Duration
+	// Now returns the current time, defaults to time.Now; inject a fake clock for deterministic expiry
+	Now func() time.Time
+}
+
+// CacheStats are the local cache counters of a CacheStorage
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// CacheStorage is a two-level Storage: positive Exists/Get results of the remote Storage are
+// cached in a bounded in-process LRU until the remote key expires, and writes go through to
+// the remote Storage. Absent keys are never cached. It is safe for concurrent use.
+type CacheStorage struct {
+	remote Storage
+	local  *MemoryStorage
+	maxTTL time.Duration
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewCacheStorage wraps remote with a local LRU cache; optional capabilities of remote are kept.
+// The local sweeper runs until ctx is done or Close is called.
+//
+// A key deleted by another process, such as an idempotency key released by Forget after a
+// failed request, is still found locally for up to MaxTTL: retries are rejected as duplicates
+// until it expires. A longer MaxTTL saves more remote lookups at the cost of that staleness.
+func NewCacheStorage(ctx context.Context, remote Storage, config ...CacheStorageConfig) *CacheStorage {
+	var cfg CacheStorageConfig
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = 10_000
+	}
+	if cfg.MaxTTL == 0 {
+		cfg.MaxTTL = DefaultCacheMaxTTL
+	}
+
+	return &CacheStorage{
+		remote: remote,
+		local:  NewMemoryStorage(ctx, MemoryStorageConfig{MaxSize: cfg.MaxSize, Now: cfg.Now}),
+		maxTTL: cfg.MaxTTL,
+	}
+}
+
+// Close stops the local sweeper; the remote Storage is left open
+func (c *CacheStorage) Close() {
+	c.local.Close()
+}
+
+// Stats returns the local cache hit and miss counters
+func (c *CacheStorage) Stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
+
+// Get retrieves a value from the local cache, or from the remote Storage caching it
+func (c *CacheStorage) Get(ctx context.Context, key []byte) ([]byte, error) {
+	value, err := c.local.Get(ctx, key)
+	if err == nil && value != nil {
+		c.hits.Add(1)
+		return value, nil
+	}
+
+	c.misses.Add(1)
+	return c.fill(ctx, key)
+}
+
+// Exists checks the local cache, or reads the remote value caching it
+func (c *CacheStorage) Exists(ctx context.Context, key []byte) (bool, error) {
+	exists, err := c.local.Exists(ctx, key)
+	if err == nil && exists {
+		c.hits.Add(1)
+		return true, nil
+	}
+
+	c.misses.Add(1)
+	value, err := c.fill(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return value != nil, nil
+}
+
+// fill reads key from the remote Storage and caches it for its remaining TTL
+func (c *CacheStorage) fill(ctx context.Context, key []byte) ([]byte, error) {
+	value, err := c.remote.Get(ctx, key)
+	if err != nil || value == nil {
+		return value, err
+	}
+
+	ttl, err := c.remote.TTL(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case ttl == -1: // no expiration
+		c.cache(ctx, key, value, 0)
+	case ttl > 0:
+		c.cache(ctx, key, value, ttl)
+	}
+	return value, nil
+}
+
+// cache keeps value locally for ttl capped at MaxTTL; a non-positive ttl means no expiration
+func (c *CacheStorage) cache(ctx context.Context, key []byte, value []byte, ttl time.Duration) {
+	if c.maxTTL > 0 && (ttl <= 0 || ttl > c.maxTTL) {
+		ttl = c.maxTTL
+	}
+	_ = c.local.SetEX(ctx, key, value, ttl)
+}
+
+// TTL retrieves the remaining time-to-live from the remote Storage
+func (c *CacheStorage) TTL(ctx context.Context, key []byte) (time.Duration, error) {
+	return c.remote.TTL(ctx, key)
+}
+
+// SetEX stores a value in the remote Storage and caches it
+func (c *CacheStorage) SetEX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error {
+	err := c.remote.SetEX(ctx, key, value, expiration...)
+	if err != nil {
+		_, _ = c.local.Del(ctx, key)
+		return err
+	}
+
+	c.cache(ctx, key, value, expirationOf(expiration))
+	return nil
+}
+
+// Del removes a key from the remote Storage and the local cache
+func (c *CacheStorage) Del(ctx context.Context, key []byte) (bool, error) {
+	_, _ = c.local.Del(ctx, key)
+	return c.remote.Del(ctx, key)
+}
+
+// SetNX fails right away on a locally cached key, otherwise it claims the key in the remote
+// Storage and caches it when claimed; a key claimed by another process is cached from the remote
+func (c *CacheStorage) SetNX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) (bool, error) {
+	exists, err := c.local.Exists(ctx, key)
+	if err == nil && exists {
+		c.hits.Add(1)
+		return false, nil
+	}
+
+	c.misses.Add(1)
+	ok, err := SetNX(ctx, c.remote, key, value, expirationOf(expiration))
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		// duplicates tend to come in bursts, the next ones are answered locally
+		_, _ = c.fill(ctx, key)
+		return false, nil
+	}
+
+	c.cache(ctx, key, value, expirationOf(expiration))
+	return true, nil
+}
+
+// Expire refreshes the expiration of a key in the remote Storage and of its cached copy
+func (c *CacheStorage) Expire(ctx context.Context, key []byte, expiration time.Duration) (bool, error) {
+	ok, err := Expire(ctx, c.remote, key, expiration)
+	if err != nil || !ok || expiration <= 0 {
+		_, _ = c.local.Del(ctx, key)
+		return ok, err
+	}
+
+	if c.maxTTL > 0 && expiration > c.maxTTL {
+		expiration = c.maxTTL
+	}
+	_, _ = c.local.Expire(ctx, key, expiration)
+	return true, nil
+}
+
+// Scan enumerates the keys of the remote Storage, the source of truth
+func (c *CacheStorage) Scan(ctx context.Context, prefix []byte, fn func(key []byte) error) error {
+	return Scan(ctx, c.remote, prefix, fn)
+}
+
+// Purge deletes the keys under prefix from the remote Storage and the local cache
+func (c *CacheStorage) Purge(ctx context.Context, prefix []byte) (int64, error) {
+	_, _ = c.local.Purge(ctx, prefix)
+	return Purge(ctx, c.remote, prefix)
+}
+
+// CompareAndSwap matches a locally cached value right away, as nothing is replaced on a match;
+// otherwise it compares and replaces the value in the remote Storage, caching the value it ends up with
+func (c *CacheStorage) CompareAndSwap(ctx context.Context, key []byte, value []byte, replace bool, expiration ...time.Duration) (bool, bool, error) {
+	cached, err := c.local.Get(ctx, key)
+	if err == nil && cached != nil && bytes.Equal(cached, value) {
+		c.hits.Add(1)
+		return true, false, nil
+	}
+
+	c.misses.Add(1)
+	matched, replaced, err := CompareAndSwap(ctx, c.remote, key, value, replace, expirationOf(expiration))
+	if err != nil {
+		_, _ = c.local.Del(ctx, key)
+		return false, false, err
+	}
+
+	switch {
+	case replaced:
+		c.cache(ctx, key, value, expirationOf(expiration))
+	case matched:
+		_, _ = c.fill(ctx, key)
+	}
+	return matched, replaced, nil
+}
+
+// Incr increments a counter in the remote Storage and drops its cached copy, which is stale from now on
+func (c *CacheStorage) Incr(ctx context.Context, key []byte, expiration time.Duration) (int64, time.Duration, error) {
+	_, _ = c.local.Del(ctx, key)
+	return Incr(ctx, c.remote, key, expiration)
+}
+
+// ExistsBatch checks the local cache and asks the remote Storage about the misses only, which are not cached
+func (c *CacheStorage) ExistsBatch(ctx context.Context, keys [][]byte) ([]bool, error) {
+	exists := make([]bool, len(keys))
+
+	var misses [][]byte
+	var indexes []int
+	for i, key := range keys {
+		ok, err := c.local.Exists(ctx, key)
+		if err == nil && ok {
+			c.hits.Add(1)
+			exists[i] = true
+			continue
+		}
+		c.misses.Add(1)
+		misses = append(misses, key)
+		indexes = append(indexes, i)
+	}
+	if len(misses) == 0 {
+		return exists, nil
+	}
+
+	found, err := ExistsBatch(ctx, c.remote, misses)
+	if err != nil {
+		return nil, err
+	}
+	for i, idx := range indexes {
+		exists[idx] = found[i]
+	}
+	return exists, nil
+}
+
+// SetEXBatch stores many values in the remote Storage and caches them
+func (c *CacheStorage) SetEXBatch(ctx context.Context, keys [][]byte, values [][]byte, expiration ...time.Duration) error {
+	err := SetEXBatch(ctx, c.remote, keys, values, expirationOf(expiration))
+	if err != nil {
+		for _, key := range keys {
+			_, _ = c.local.Del(ctx, key)
+		}
+		return err
+	}
+
+	for i, key := range keys {
+		c.cache(ctx, key, values[i], expirationOf(expiration))
+	}
+	return nil
+}
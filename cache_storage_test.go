@@ -0,0 +1,269 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheStorage(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(config CacheStorageConfig) (*countingStorage, *CacheStorage, *fakeClock) {
+		clock := newFakeClock()
+		remote := &countingStorage{Storage: NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})}
+		config.Now = clock.Now
+		cache := NewCacheStorage(ctx, remote, config)
+		t.Cleanup(cache.Close)
+		return remote, cache, clock
+	}
+
+	t.Run("Positive lookups are cached", func(t *testing.T) {
+		remote, cache, _ := setup(CacheStorageConfig{})
+
+		assert.NoError(t, remote.SetEX(ctx, []byte("key"), []byte("value"), time.Minute))
+
+		for i := 0; i < 5; i++ {
+			exists, err := cache.Exists(ctx, []byte("key"))
+			assert.NoError(t, err)
+			assert.True(t, exists)
+		}
+
+		val, err := cache.Get(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), val)
+
+		assert.Equal(t, int32(1), remote.lookups.Load())
+		assert.Equal(t, CacheStats{Hits: 5, Misses: 1}, cache.Stats())
+	})
+
+	t.Run("Absent keys are not cached", func(t *testing.T) {
+		remote, cache, _ := setup(CacheStorageConfig{})
+
+		for i := 0; i < 3; i++ {
+			exists, err := cache.Exists(ctx, []byte("missing"))
+			assert.NoError(t, err)
+			assert.False(t, exists)
+		}
+		assert.Equal(t, int32(3), remote.lookups.Load())
+		assert.Equal(t, CacheStats{Misses: 3}, cache.Stats())
+
+		// Written by another process, seen on the next lookup
+		assert.NoError(t, remote.SetEX(ctx, []byte("missing"), []byte("value"), time.Minute))
+		exists, err := cache.Exists(ctx, []byte("missing"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("Cached entries honour the remote TTL", func(t *testing.T) {
+		remote, cache, clock := setup(CacheStorageConfig{MaxTTL: -1})
+
+		assert.NoError(t, remote.SetEX(ctx, []byte("key"), []byte("value"), time.Minute))
+		clock.Advance(40 * time.Second)
+
+		exists, err := cache.Exists(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+
+		// Without MaxTTL the cached copy lives as long as the remote key
+		clock.Advance(10 * time.Second)
+		exists, err = cache.Exists(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, int32(1), remote.lookups.Load())
+
+		// and expires with it, 20s after the first lookup
+		clock.Advance(11 * time.Second)
+		exists, err = cache.Exists(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+		assert.Equal(t, int32(2), remote.lookups.Load())
+	})
+
+	t.Run("MaxTTL bounds staleness", func(t *testing.T) {
+		// MaxTTL defaults to DefaultCacheMaxTTL
+		remote, cache, clock := setup(CacheStorageConfig{})
+
+		assert.NoError(t, cache.SetEX(ctx, []byte("key"), []byte("value"), time.Minute))
+
+		// Deleted behind the cache's back
+		_, err := remote.Del(ctx, []byte("key"))
+		assert.NoError(t, err)
+
+		exists, err := cache.Exists(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.True(t, exists, "stale until MaxTTL")
+
+		clock.Advance(6 * time.Second)
+		exists, err = cache.Exists(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("Writes go through", func(t *testing.T) {
+		remote, cache, clock := setup(CacheStorageConfig{})
+
+		assert.NoError(t, cache.SetEX(ctx, []byte("key"), []byte("value"), time.Minute))
+		val, err := remote.Get(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), val)
+
+		ok, err := cache.SetNX(ctx, []byte("key"), []byte("other"), time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+
+		deleted, err := cache.Del(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.True(t, deleted)
+
+		exists, err := cache.Exists(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		ok, err = cache.SetNX(ctx, []byte("key"), []byte("other"), time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		// Served from the cache after the claim
+		remote.lookups.Store(0)
+		val, err = cache.Get(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("other"), val)
+		assert.Equal(t, int32(0), remote.lookups.Load())
+
+		// Expire shortens the cached copy along with the remote key
+		ok, err = cache.Expire(ctx, []byte("key"), 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		clock.Advance(11 * time.Second)
+		exists, err = cache.Exists(ctx, []byte("key"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("Batch checks the cache first", func(t *testing.T) {
+		remote, cache, _ := setup(CacheStorageConfig{})
+		assert.NoError(t, cache.SetEXBatch(ctx, [][]byte{[]byte("a"), []byte("b")}, [][]byte{[]byte("1"), []byte("2")}, time.Minute))
+		assert.NoError(t, remote.SetEX(ctx, []byte("c"), []byte("3"), time.Minute))
+
+		exists, err := cache.ExistsBatch(ctx, [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")})
+		assert.NoError(t, err)
+		assert.Equal(t, []bool{true, true, true, false}, exists)
+		assert.Equal(t, CacheStats{Hits: 2, Misses: 2}, cache.Stats())
+	})
+
+	t.Run("Deduper on a cached Redis storage", func(t *testing.T) {
+		mr, err := miniredis.Run()
+		assert.NoError(t, err)
+		defer mr.Close()
+
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer client.Close()
+
+		cache := NewCacheStorage(ctx, NewRedisStorage(ctx, client), CacheStorageConfig{MaxSize: 2})
+		defer cache.Close()
+
+		handler := func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }
+		deduper := New(handler, cache)
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, "webhook-1", DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		// Retried in a tight loop, answered locally
+		for i := 0; i < 10; i++ {
+			isDuplicate, err = deduper.IsDuplicate(ctx, "webhook-1", DefaultHashStrategy())
+			assert.NoError(t, err)
+			assert.True(t, isDuplicate)
+		}
+		// the claim of the first call missed the cache
+		assert.Equal(t, CacheStats{Hits: 10, Misses: 1}, cache.Stats())
+		assert.True(t, mr.Exists("dedup:string:webhook-1"))
+	})
+
+	t.Run("Claims of hot duplicates are answered locally", func(t *testing.T) {
+		remote, cache, _ := setup(CacheStorageConfig{})
+		handler := func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }
+		deduper := New(handler, cache)
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, "webhook-1", DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		remote.lookups.Store(0)
+		for i := 0; i < 5; i++ {
+			isDuplicate, err = deduper.IsDuplicate(ctx, "webhook-1", DefaultHashStrategy(), time.Minute)
+			assert.NoError(t, err)
+			assert.True(t, isDuplicate)
+		}
+		assert.Equal(t, CacheStats{Hits: 5, Misses: 1}, cache.Stats())
+		assert.Equal(t, int32(0), remote.lookups.Load())
+
+		// claimed by another process, cached after the first duplicate
+		assert.NoError(t, remote.SetEX(ctx, []byte("dedup:string:webhook-2"), []byte("webhook-2"), time.Minute))
+		for i := 0; i < 3; i++ {
+			isDuplicate, err = deduper.IsDuplicate(ctx, "webhook-2", DefaultHashStrategy(), time.Minute)
+			assert.NoError(t, err)
+			assert.True(t, isDuplicate)
+		}
+		assert.Equal(t, CacheStats{Hits: 7, Misses: 2}, cache.Stats())
+
+		// matching values are answered locally too
+		for i := 0; i < 3; i++ {
+			isDuplicate, err = deduper.IsValueDuplicate(ctx, "webhook-1", DefaultHashStrategy(), time.Minute)
+			assert.NoError(t, err)
+			assert.True(t, isDuplicate)
+		}
+		assert.Equal(t, CacheStats{Hits: 10, Misses: 2}, cache.Stats())
+	})
+
+	t.Run("Purge drops cached keys", func(t *testing.T) {
+		remote := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		cache := NewCacheStorage(ctx, remote)
+		t.Cleanup(cache.Close)
+
+		assert.NoError(t, cache.SetEX(ctx, []byte("acme:1"), []byte("value"), time.Minute))
+		assert.NoError(t, cache.SetEX(ctx, []byte("globex:1"), []byte("value"), time.Minute))
+
+		purged, err := cache.Purge(ctx, []byte("acme:"))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+
+		exists, err := cache.Exists(ctx, []byte("acme:1"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+		exists, err = cache.Exists(ctx, []byte("globex:1"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("Concurrent access", func(t *testing.T) {
+		_, cache, _ := setup(CacheStorageConfig{MaxSize: 16})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					key := []byte(fmt.Sprintf("key-%d", (i+j)%32))
+					_ = cache.SetEX(ctx, key, []byte("v"), time.Minute)
+					_, _ = cache.Exists(ctx, key)
+					_, _ = cache.Get(ctx, key)
+					_, _ = cache.Del(ctx, key)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		stats := cache.Stats()
+		assert.Equal(t, uint64(8*100*2), stats.Hits+stats.Misses)
+	})
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/pixie-sh/errors-go"
+)
+
+// maxLineSize bounds a JSON line read by import and the file store
+const maxLineSize = 64 << 20
+
+// record is a line written by export and read by import; key and value are base64 encoded
+type record struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	// TTL is the time left in milliseconds when exported, -1 when the key never expires
+	TTL int64 `json:"ttl_ms"`
+}
+
+// ttlBuckets are the upper bounds of the stats histogram
+var ttlBuckets = []time.Duration{time.Minute, 10 * time.Minute, time.Hour, 24 * time.Hour}
+
+// keyCommand runs key, inspect and forget on the key built from the material
+func keyCommand(ctx context.Context, cfg config, storage dedup.Storage, command string, args []string, out io.Writer) error {
+	material, err := materialOf(cfg, command, args)
+	if err != nil {
+		return err
+	}
+	d, strategy, err := deduper(cfg, storage)
+	if err != nil {
+		return err
+	}
+
+	key, err := d.Key(ctx, material, strategy)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "inspect":
+		return inspect(ctx, storage, key, out)
+	case "forget":
+		err = d.Forget(ctx, material, strategy)
+		if _, missing := errors.Has(err, dedup.DedupMissingKeyErrorCode); missing {
+			_, err = fmt.Fprintf(out, "%s not found\n", display(key))
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(out, "%s forgotten\n", display(key))
+		return err
+	default:
+		_, err = fmt.Fprintln(out, display(key))
+		return err
+	}
+}
+
+func inspect(ctx context.Context, storage dedup.Storage, key []byte, out io.Writer) error {
+	value, err := storage.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	ttl, err := storage.TTL(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "key\t%s\n", display(key))
+	fmt.Fprintf(w, "key hex\t%s\n", hex.EncodeToString(key))
+	fmt.Fprintf(w, "exists\t%t\n", value != nil)
+	if value != nil {
+		fmt.Fprintf(w, "ttl\t%s\n", ttlText(ttl))
+		fmt.Fprintf(w, "value\t%s\n", display(value))
+	}
+	return w.Flush()
+}
+
+// eachKey parses the -prefix flag of scan, stats and export and calls fn with every key under it.
+// Without -prefix the keys are those of the Deduper prefix, or of its -scope when given.
+func eachKey(ctx context.Context, cfg config, storage dedup.Storage, command string, args []string, fn func(key []byte) error) error {
+	prefix, err := prefixFlag(command, args)
+	if err != nil {
+		return err
+	}
+	if prefix != "" {
+		return dedup.Scan(ctx, storage, []byte(prefix), fn)
+	}
+
+	d, _, err := deduper(cfg, storage)
+	if err != nil {
+		return err
+	}
+	if cfg.scope != "" {
+		return d.ScopeKeys(ctx, cfg.scope, fn)
+	}
+	return dedup.Scan(ctx, storage, []byte(d.Prefix()), fn)
+}
+
+// prefixFlag parses the -prefix flag of the commands working on every key under a prefix
+func prefixFlag(command string, args []string) (string, error) {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	prefix := fs.String("prefix", "", "raw key prefix, defaults to the Deduper prefix")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return "", errors.Wrap(err, "%s; %s", command, err.Error())
+	}
+	if fs.NArg() > 0 {
+		return "", errors.New("%s takes no arguments", command)
+	}
+	return *prefix, nil
+}
+
+func scanCommand(ctx context.Context, cfg config, storage dedup.Storage, args []string, out io.Writer) error {
+	return eachKey(ctx, cfg, storage, "scan", args, func(key []byte) error {
+		_, err := fmt.Fprintln(out, display(key))
+		return err
+	})
+}
+
+// purgeCommand deletes the keys under -prefix, or those of the Deduper prefix or of its -scope
+func purgeCommand(ctx context.Context, cfg config, storage dedup.Storage, args []string, out io.Writer) error {
+	prefix, err := prefixFlag("purge", args)
+	if err != nil {
+		return err
+	}
+
+	purged, err := purgeKeys(ctx, cfg, storage, prefix)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "purged %d keys\n", purged)
+	return err
+}
+
+// purgeKeys deletes the keys eachKey would enumerate, in bulk when the storage implements dedup.PurgeStorage
+func purgeKeys(ctx context.Context, cfg config, storage dedup.Storage, prefix string) (int64, error) {
+	if prefix != "" {
+		return dedup.Purge(ctx, storage, []byte(prefix))
+	}
+
+	d, _, err := deduper(cfg, storage)
+	if err != nil {
+		return 0, err
+	}
+	if cfg.scope != "" {
+		purged, err := d.PurgeScope(ctx, cfg.scope)
+		return int64(purged), err
+	}
+	return dedup.Purge(ctx, storage, []byte(d.Prefix()))
+}
+
+func statsCommand(ctx context.Context, cfg config, storage dedup.Storage, args []string, out io.Writer) error {
+	var total, persistent int
+	counts := make([]int, len(ttlBuckets)+1)
+
+	err := eachKey(ctx, cfg, storage, "stats", args, func(key []byte) error {
+		ttl, err := storage.TTL(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case ttl == -1:
+			persistent++
+		case ttl <= 0: // expired while scanning
+			return nil
+		default:
+			bucket := len(ttlBuckets)
+			for i, limit := range ttlBuckets {
+				if ttl < limit {
+					bucket = i
+					break
+				}
+			}
+			counts[bucket]++
+		}
+		total++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "keys\t%d\n", total)
+	fmt.Fprintf(w, "no expiry\t%d\n", persistent)
+	for i, limit := range ttlBuckets {
+		fmt.Fprintf(w, "ttl < %s\t%d\n", durationText(limit), counts[i])
+	}
+	fmt.Fprintf(w, "ttl >= %s\t%d\n", durationText(ttlBuckets[len(ttlBuckets)-1]), counts[len(ttlBuckets)])
+	return w.Flush()
+}
+
+func exportCommand(ctx context.Context, cfg config, storage dedup.Storage, args []string, out io.Writer) error {
+	writer := bufio.NewWriter(out)
+	encoder := json.NewEncoder(writer)
+
+	err := eachKey(ctx, cfg, storage, "export", args, func(key []byte) error {
+		value, err := storage.Get(ctx, key)
+		if err != nil || value == nil {
+			return err
+		}
+		ttl, err := storage.TTL(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case ttl == -1:
+			return encoder.Encode(record{Key: key, Value: value, TTL: -1})
+		case ttl > 0:
+			return encoder.Encode(record{Key: key, Value: value, TTL: max(ttl.Milliseconds(), 1)})
+		default: // expired while scanning
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func importCommand(ctx context.Context, storage dedup.Storage, args []string, in io.Reader, out io.Writer) error {
+	if len(args) > 0 {
+		return errors.New("import takes no arguments, it reads JSON lines from stdin")
+	}
+
+	// the file store saves once at the end, with whatever was imported before an error
+	f, held := storage.(*fileStore)
+	if held {
+		f.hold()
+	}
+
+	imported, err := importRecords(ctx, storage, in)
+	if held {
+		if flushErr := f.flush(ctx); err == nil {
+			err = flushErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "imported %d keys\n", imported)
+	return err
+}
+
+// importRecords stores every record read from in and returns how many were stored
+func importRecords(ctx context.Context, storage dedup.Storage, in io.Reader) (int, error) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(nil, maxLineSize)
+
+	imported := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var r record
+		err := json.Unmarshal(scanner.Bytes(), &r)
+		if err != nil {
+			return imported, errors.Wrap(err, "line %d; %s", line, err.Error())
+		}
+		if len(r.Key) == 0 || r.TTL == 0 || r.TTL < -1 {
+			return imported, errors.New("line %d has no key or an invalid ttl_ms", line)
+		}
+
+		expiration := time.Duration(0) // never expires
+		if r.TTL > 0 {
+			expiration = time.Duration(r.TTL) * time.Millisecond
+		}
+		err = storage.SetEX(ctx, r.Key, r.Value, expiration)
+		if err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	return imported, scanner.Err()
+}
+
+// display returns b as text when it is printable, quoted with escapes otherwise
+func display(b []byte) string {
+	if !utf8.Valid(b) {
+		return strconv.Quote(string(b))
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) {
+			return strconv.Quote(string(b))
+		}
+	}
+	return string(b)
+}
+
+func ttlText(ttl time.Duration) string {
+	if ttl == -1 {
+		return "no expiry"
+	}
+	return ttl.Round(time.Millisecond).String()
+}
+
+// durationText drops the zero minutes and seconds of whole durations, 1h rather than 1h0m0s
+func durationText(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	default:
+		return d.String()
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/pixie-sh/errors-go"
+)
+
+// fileRecord is a line of a file store, expiring at an absolute time
+type fileRecord struct {
+	Key       []byte    `json:"key"`
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// fileStore is a dedup.Storage kept in memory and saved as JSON lines after every write,
+// for inspecting dumps and testing without a Redis
+type fileStore struct {
+	path   string
+	memory *dedup.MemoryStorage
+	now    func() time.Time
+	held   bool // writes are saved by flush rather than one by one
+}
+
+var (
+	_ dedup.ScanStorage  = (*fileStore)(nil)
+	_ dedup.PurgeStorage = (*fileStore)(nil)
+)
+
+// openFileStore loads the store at path, a missing file is an empty store
+func openFileStore(ctx context.Context, path string) (*fileStore, error) {
+	f := &fileStore{
+		path:   path,
+		memory: dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}),
+		now:    time.Now,
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		var record fileRecord
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return nil, errors.Wrap(err, "%s:%d; %s", path, line, err.Error())
+		}
+
+		ttl := time.Duration(0)
+		if !record.ExpiresAt.IsZero() {
+			ttl = record.ExpiresAt.Sub(f.now())
+			if ttl <= 0 {
+				continue
+			}
+		}
+		err = f.memory.SetEX(ctx, record.Key, record.Value, ttl)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, scanner.Err()
+}
+
+// save rewrites the file with the live keys, through a temporary file so readers never see half of it
+func (f *fileStore) save(ctx context.Context) error {
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	err = f.memory.Scan(ctx, nil, func(key []byte) error {
+		value, err := f.memory.Get(ctx, key)
+		if err != nil || value == nil {
+			return err
+		}
+		ttl, err := f.memory.TTL(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		record := fileRecord{Key: key, Value: value}
+		if ttl > 0 {
+			record.ExpiresAt = f.now().Add(ttl).UTC()
+		}
+		return encoder.Encode(record)
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// hold defers saving until flush, for bulk writes
+func (f *fileStore) hold() {
+	f.held = true
+}
+
+// flush saves the writes made since hold
+func (f *fileStore) flush(ctx context.Context) error {
+	f.held = false
+	return f.save(ctx)
+}
+
+func (f *fileStore) Get(ctx context.Context, key []byte) ([]byte, error) {
+	return f.memory.Get(ctx, key)
+}
+
+func (f *fileStore) TTL(ctx context.Context, key []byte) (time.Duration, error) {
+	return f.memory.TTL(ctx, key)
+}
+
+func (f *fileStore) Exists(ctx context.Context, key []byte) (bool, error) {
+	return f.memory.Exists(ctx, key)
+}
+
+func (f *fileStore) SetEX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error {
+	err := f.memory.SetEX(ctx, key, value, expiration...)
+	if err != nil || f.held {
+		return err
+	}
+	return f.save(ctx)
+}
+
+func (f *fileStore) Del(ctx context.Context, key []byte) (bool, error) {
+	deleted, err := f.memory.Del(ctx, key)
+	if err != nil || !deleted || f.held {
+		return deleted, err
+	}
+	return true, f.save(ctx)
+}
+
+func (f *fileStore) Scan(ctx context.Context, prefix []byte, fn func(key []byte) error) error {
+	return f.memory.Scan(ctx, prefix, fn)
+}
+
+func (f *fileStore) Purge(ctx context.Context, prefix []byte) (int64, error) {
+	purged, err := f.memory.Purge(ctx, prefix)
+	if err != nil || purged == 0 || f.held {
+		return purged, err
+	}
+	return purged, f.save(ctx)
+}
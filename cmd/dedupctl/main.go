@@ -0,0 +1,222 @@
+// Command dedupctl inspects and manages dedup keys in Redis or in a file store.
+//
+// Given the prefix, hash strategy and raw key material of a Deduper, it computes the exact key
+// the library builds, so "why was this message dropped?" is one command away:
+//
+//	dedupctl -redis localhost:6379 -prefix dedup:orders: key order-1
+//	dedupctl -redis localhost:6379 -prefix dedup:orders: -scope acme inspect order-1
+//	dedupctl -redis localhost:6379 -prefix dedup:orders: forget order-1
+//	dedupctl -redis localhost:6379 scan -prefix dedup:orders:
+//	dedupctl -redis localhost:6379 stats -prefix dedup:orders:
+//	dedupctl -redis localhost:6379 -prefix dedup:orders: -scope acme purge
+//	dedupctl -redis localhost:6379 export -prefix dedup:orders: > orders.jsonl
+//	dedupctl -file orders.db import < orders.jsonl
+//
+// Run dedupctl -h for every flag.
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/pixie-sh/errors-go"
+	"github.com/redis/go-redis/v9"
+)
+
+const usage = `usage: dedupctl [flags] <command> [command flags] [key material]
+
+commands:
+  key <material>       print the key built for the material
+  inspect <material>   print the key, whether it exists, its value and TTL
+  forget <material>    delete the key
+  scan                 list the keys under a prefix
+  stats                count the keys under a prefix with a TTL histogram
+  purge                delete the keys under a prefix
+  export               write the keys under a prefix as JSON lines
+  import               read keys written by export
+
+flags:
+`
+
+// config holds the global flags
+type config struct {
+	redis       string
+	file        string
+	prefix      string
+	version     int
+	scope       string
+	hashMode    string
+	threshold   int
+	hasher      string
+	encoding    string
+	hashTag     bool
+	hexMaterial bool
+}
+
+func main() {
+	err := run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	if err == flag.ErrHelp {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "dedupctl:", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command line args, reading imports from in and writing results to out
+func run(ctx context.Context, args []string, in io.Reader, out io.Writer, errOut io.Writer) error {
+	var cfg config
+	fs := flag.NewFlagSet("dedupctl", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.Usage = func() {
+		fmt.Fprint(errOut, usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&cfg.redis, "redis", "", "Redis address, comma separated for a cluster, or a redis:// URL")
+	fs.StringVar(&cfg.file, "file", "", "file store path, used instead of Redis")
+	fs.StringVar(&cfg.prefix, "prefix", "", "key prefix of the Deduper, such as dedup:orders:")
+	fs.IntVar(&cfg.version, "version", 0, "namespace version of the Deduper, see WithVersion")
+	fs.StringVar(&cfg.scope, "scope", "", "scope of the key, such as a tenant ID")
+	fs.StringVar(&cfg.hashMode, "hash", "auto", "key hash mode: auto, always or never")
+	fs.IntVar(&cfg.threshold, "threshold", dedup.DefaultHashStrategy().KeyThreshold, "longest raw key material under -hash auto")
+	fs.StringVar(&cfg.hasher, "hasher", "sha256", "key hash function: sha256, sha512, sha1 or md5")
+	fs.StringVar(&cfg.encoding, "encoding", "legacy", "key encoding: legacy, tagged, hex or base64")
+	fs.BoolVar(&cfg.hashTag, "hash-tag", false, "wrap the prefix in a Redis Cluster hash tag")
+	fs.BoolVar(&cfg.hexMaterial, "hex", false, "the key material is hex encoded")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	command, rest := fs.Arg(0), fs.Args()[1:]
+
+	// key only builds the key, it needs no storage
+	var storage dedup.Storage
+	if command != "key" || cfg.redis != "" || cfg.file != "" {
+		storage, err = openStorage(ctx, cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch command {
+	case "key", "inspect", "forget":
+		return keyCommand(ctx, cfg, storage, command, rest, out)
+	case "scan":
+		return scanCommand(ctx, cfg, storage, rest, out)
+	case "stats":
+		return statsCommand(ctx, cfg, storage, rest, out)
+	case "purge":
+		return purgeCommand(ctx, cfg, storage, rest, out)
+	case "export":
+		return exportCommand(ctx, cfg, storage, rest, out)
+	case "import":
+		return importCommand(ctx, storage, rest, in, out)
+	default:
+		fs.Usage()
+		return errors.New("unknown command '%s'", command)
+	}
+}
+
+// openStorage opens the file store or the Redis of the flags
+func openStorage(ctx context.Context, cfg config) (dedup.Storage, error) {
+	switch {
+	case cfg.file != "" && cfg.redis != "":
+		return nil, errors.New("-file and -redis are exclusive")
+	case cfg.file != "":
+		return openFileStore(ctx, cfg.file)
+	case strings.HasPrefix(cfg.redis, "redis://") || strings.HasPrefix(cfg.redis, "rediss://"):
+		options, err := redis.ParseURL(cfg.redis)
+		if err != nil {
+			return nil, err
+		}
+		return dedup.NewRedisStorage(ctx, redis.NewClient(options)), nil
+	case cfg.redis != "":
+		client := redis.NewUniversalClient(&redis.UniversalOptions{Addrs: strings.Split(cfg.redis, ",")})
+		return dedup.NewRedisStorage(ctx, client), nil
+	default:
+		return nil, errors.New("one of -redis or -file is required")
+	}
+}
+
+// deduper builds the Deduper of the flags, its key handler returns the raw key material
+func deduper(cfg config, storage dedup.Storage) (*dedup.Deduper, dedup.HashStrategy, error) {
+	strategy := dedup.DefaultHashStrategy()
+	strategy.KeyThreshold = cfg.threshold
+	switch cfg.hashMode {
+	case "auto":
+		strategy.KeyHashMode = dedup.AutoSmart
+	case "always":
+		strategy.KeyHashMode = dedup.AlwaysHash
+	case "never":
+		strategy.KeyHashMode = dedup.NeverHash
+	default:
+		return nil, strategy, errors.New("unknown hash mode '%s'", cfg.hashMode)
+	}
+
+	hashers := map[string]func() hash.Hash{"sha256": sha256.New, "sha512": sha512.New, "sha1": sha1.New, "md5": md5.New}
+	hasher, ok := hashers[cfg.hasher]
+	if !ok {
+		return nil, strategy, errors.New("unknown hasher '%s'", cfg.hasher)
+	}
+
+	encodings := map[string]dedup.KeyEncoding{"legacy": dedup.LegacyKeys, "tagged": dedup.TaggedKeys, "hex": dedup.HexKeys, "base64": dedup.Base64Keys}
+	encoding, ok := encodings[cfg.encoding]
+	if !ok {
+		return nil, strategy, errors.New("unknown key encoding '%s'", cfg.encoding)
+	}
+
+	if cfg.prefix == "" {
+		return nil, strategy, errors.New("-prefix is required to build keys")
+	}
+
+	opts := []dedup.Option{
+		dedup.WithPrefix(cfg.prefix),
+		dedup.WithVersion(cfg.version),
+		dedup.WithHasher(hasher),
+		dedup.WithKeyEncoding(encoding),
+	}
+	if cfg.hashTag {
+		opts = append(opts, dedup.WithHashTag())
+	}
+
+	handler := func(_ context.Context, material []byte) ([]byte, error) { return material, nil }
+	d := dedup.New(handler, storage, opts...)
+	if cfg.scope != "" {
+		d = d.Scoped(cfg.scope)
+	}
+	return d, strategy, nil
+}
+
+// materialOf returns the single key material argument
+func materialOf(cfg config, command string, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("%s takes exactly one key material argument", command)
+	}
+	if !cfg.hexMaterial {
+		return []byte(args[0]), nil
+	}
+
+	material, err := hex.DecodeString(args[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "key material is not hex; %s", err.Error())
+	}
+	return material, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func dedupctl(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	err := run(context.Background(), args, strings.NewReader(stdin), &out, &errOut)
+	return out.String(), err
+}
+
+func TestDedupctl(t *testing.T) {
+	ctx := context.Background()
+	handler := func(_ context.Context, id string) ([]byte, error) { return []byte(id), nil }
+
+	mr := miniredis.RunT(t)
+	storage := dedup.NewRedisStorage(ctx, redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	orders := dedup.New(handler, storage, dedup.WithPrefix("dedup:orders:"), dedup.WithVersion(2))
+	for _, id := range []string{"order-1", "order-2"} {
+		_, err := orders.Seen(ctx, id)
+		assert.NoError(t, err)
+	}
+	_, err := orders.Seen(dedup.ContextWithScope(ctx, "acme"), "order-1")
+	assert.NoError(t, err)
+	_, err = orders.StoreHash(ctx, []byte("pinned"), 0)
+	assert.NoError(t, err)
+
+	redisFlags := []string{"-redis", mr.Addr(), "-prefix", "dedup:orders:", "-version", "2"}
+
+	t.Run("Key", func(t *testing.T) {
+		out, err := dedupctl(t, "", "-prefix", "dedup:orders:", "-version", "2", "key", "order-1")
+		assert.NoError(t, err)
+		assert.Equal(t, "dedup:orders:v2:order-1\n", out)
+
+		// long material is hashed like the library does
+		long := strings.Repeat("x", 40)
+		digest := sha256.Sum256([]byte(long))
+		out, err = dedupctl(t, "", "-prefix", "p:", "-encoding", "hex", "key", long)
+		assert.NoError(t, err)
+		assert.Equal(t, "p:h:"+hex.EncodeToString(digest[:])+"\n", out)
+
+		out, err = dedupctl(t, "", "-prefix", "p:", "-scope", "acme", "-hex", "key", hex.EncodeToString([]byte("order-1")))
+		assert.NoError(t, err)
+		assert.Equal(t, `"p:\x00s:acme:order-1"`+"\n", out)
+
+		// binary keys are quoted
+		out, err = dedupctl(t, "", "-prefix", "p:", "key", long)
+		assert.NoError(t, err)
+		assert.Equal(t, `"p:`, out[:3])
+
+		_, err = dedupctl(t, "", "key", "order-1")
+		assert.ErrorContains(t, err, "-prefix is required")
+	})
+
+	t.Run("Inspect", func(t *testing.T) {
+		out, err := dedupctl(t, "", append(redisFlags, "inspect", "order-1")...)
+		assert.NoError(t, err)
+		assert.Contains(t, out, "key      dedup:orders:v2:order-1\n")
+		assert.Contains(t, out, "exists   true\n")
+		assert.Contains(t, out, "ttl      1h0m0s\n")
+		assert.Contains(t, out, "value    \"order-1\"\n")
+
+		out, err = dedupctl(t, "", append(redisFlags, "inspect", "pinned")...)
+		assert.NoError(t, err)
+		assert.Contains(t, out, "ttl      no expiry\n")
+
+		out, err = dedupctl(t, "", append(redisFlags, "-scope", "acme", "inspect", "order-1")...)
+		assert.NoError(t, err)
+		assert.Contains(t, out, `key      "dedup:orders:v2:\x00s:acme:order-1"`+"\n")
+
+		out, err = dedupctl(t, "", append(redisFlags, "inspect", "order-9")...)
+		assert.NoError(t, err)
+		assert.Contains(t, out, "exists   false\n")
+		assert.NotContains(t, out, "ttl")
+	})
+
+	t.Run("Scan and stats", func(t *testing.T) {
+		out, err := dedupctl(t, "", append(redisFlags, "scan")...)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{
+			"dedup:orders:v2:order-1",
+			"dedup:orders:v2:order-2",
+			`"dedup:orders:v2:\x00s:acme:order-1"`,
+			"dedup:orders:v2:pinned",
+		}, strings.Fields(out))
+
+		out, err = dedupctl(t, "", append(redisFlags, "-scope", "acme", "scan")...)
+		assert.NoError(t, err)
+		assert.Equal(t, `"dedup:orders:v2:\x00s:acme:order-1"`+"\n", out)
+
+		out, err = dedupctl(t, "", "-redis", mr.Addr(), "scan", "-prefix", "dedup:orders:v2:order")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"dedup:orders:v2:order-1", "dedup:orders:v2:order-2"}, strings.Fields(out))
+
+		out, err = dedupctl(t, "", append(redisFlags, "stats")...)
+		assert.NoError(t, err)
+		assert.Equal(t, strings.Join([]string{
+			"keys        4",
+			"no expiry   1",
+			"ttl < 1m    0",
+			"ttl < 10m   0",
+			"ttl < 1h    0",
+			"ttl < 24h   3",
+			"ttl >= 24h  0",
+		}, "\n")+"\n", out)
+	})
+
+	t.Run("Export and import", func(t *testing.T) {
+		exported, err := dedupctl(t, "", append(redisFlags, "export")...)
+		assert.NoError(t, err)
+		assert.Len(t, strings.Split(strings.TrimSpace(exported), "\n"), 4)
+
+		path := filepath.Join(t.TempDir(), "orders.jsonl")
+		out, err := dedupctl(t, exported, "-file", path, "import")
+		assert.NoError(t, err)
+		assert.Equal(t, "imported 4 keys\n", out)
+
+		// the file store is reopened by every command
+		fileFlags := []string{"-file", path, "-prefix", "dedup:orders:", "-version", "2"}
+		out, err = dedupctl(t, "", append(fileFlags, "inspect", "order-2")...)
+		assert.NoError(t, err)
+		assert.Contains(t, out, "exists   true\n")
+		assert.Contains(t, out, "value    \"order-2\"\n")
+
+		out, err = dedupctl(t, "", append(fileFlags, "inspect", "pinned")...)
+		assert.NoError(t, err)
+		assert.Contains(t, out, "ttl      no expiry\n")
+
+		reexported, err := dedupctl(t, "", append(fileFlags, "export")...)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, keysOf(t, exported), keysOf(t, reexported))
+
+		_, err = dedupctl(t, "{\"key\":\"a2V5\",\"value\":\"\",\"ttl_ms\":0}\n", "-file", path, "import")
+		assert.ErrorContains(t, err, "line 1")
+	})
+
+	t.Run("Forget", func(t *testing.T) {
+		out, err := dedupctl(t, "", append(redisFlags, "forget", "order-1")...)
+		assert.NoError(t, err)
+		assert.Equal(t, "dedup:orders:v2:order-1 forgotten\n", out)
+		assert.False(t, mr.Exists("dedup:orders:v2:order-1"))
+		assert.True(t, mr.Exists("dedup:orders:v2:\x00s:acme:order-1"))
+
+		out, err = dedupctl(t, "", append(redisFlags, "forget", "order-1")...)
+		assert.NoError(t, err)
+		assert.Equal(t, "dedup:orders:v2:order-1 not found\n", out)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		for _, id := range []string{"order-3", "order-4"} {
+			_, err := orders.Seen(dedup.ContextWithScope(ctx, "globex"), id)
+			assert.NoError(t, err)
+		}
+
+		out, err := dedupctl(t, "", append(redisFlags, "-scope", "globex", "purge")...)
+		assert.NoError(t, err)
+		assert.Equal(t, "purged 2 keys\n", out)
+		assert.False(t, mr.Exists("dedup:orders:v2:\x00s:globex:order-3"))
+		assert.True(t, mr.Exists("dedup:orders:v2:\x00s:acme:order-1"))
+
+		path := filepath.Join(t.TempDir(), "orders.jsonl")
+		_, err = dedupctl(t, "{\"key\":\"YWNtZTox\",\"value\":\"MQ==\",\"ttl_ms\":-1}\n", "-file", path, "import")
+		assert.NoError(t, err)
+		out, err = dedupctl(t, "", "-file", path, "purge", "-prefix", "acme:")
+		assert.NoError(t, err)
+		assert.Equal(t, "purged 1 keys\n", out)
+
+		// the purge was saved
+		out, err = dedupctl(t, "", "-file", path, "scan", "-prefix", "acme:")
+		assert.NoError(t, err)
+		assert.Empty(t, out)
+	})
+
+	t.Run("Usage errors", func(t *testing.T) {
+		_, err := dedupctl(t, "")
+		assert.Error(t, err)
+
+		_, err = dedupctl(t, "", append(redisFlags, "unknown")...)
+		assert.ErrorContains(t, err, "unknown command")
+
+		_, err = dedupctl(t, "", "-prefix", "p:", "inspect", "order-1")
+		assert.ErrorContains(t, err, "-redis or -file")
+
+		_, err = dedupctl(t, "", append(redisFlags, "-hash", "sometimes", "key", "order-1")...)
+		assert.ErrorContains(t, err, "unknown hash mode")
+
+		_, err = dedupctl(t, "", append(redisFlags, "inspect")...)
+		assert.ErrorContains(t, err, "exactly one")
+	})
+}
+
+func keysOf(t *testing.T, jsonl string) []string {
+	t.Helper()
+	var keys []string
+	for _, line := range strings.Split(strings.TrimSpace(jsonl), "\n") {
+		keys = append(keys, line[:strings.Index(line, `"value"`)])
+	}
+	return keys
+}
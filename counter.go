@@ -0,0 +1,54 @@
+package dedup
+
+import (
+	"context"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+)
+
+// Occurrences is the outcome of IsDuplicateN
+type Occurrences struct {
+	// Duplicate reports that Count exceeds the limit
+	Duplicate bool
+	// Count is the number of occurrences in the current window, this one included
+	Count int64
+	// Remaining is the time left in the window, -1 when it never ends
+	Remaining time.Duration
+}
+
+// IsDuplicateN allows up to limit occurrences of an entity per window: it atomically
+// increments the entity counter and reports a duplicate once the count exceeds limit.
+// The window is fixed, it starts with the first occurrence and is not extended by later ones.
+// Counters are kept apart from IsDuplicate keys, so both can be used on the same entities.
+// Storages without CounterStorage fall back to a non-atomic Get + TTL + SetEX.
+func (d *Deduper) IsDuplicateN(ctx context.Context, entity any, strategy HashStrategy, limit int64, window time.Duration) (Occurrences, error) {
+	ctx, span := d.startSpan(ctx, OpIsDuplicateN)
+	occurrences, err := d.isDuplicateN(ctx, entity, strategy, limit, window)
+	if err == nil {
+		span.set("dedup.count", occurrences.Count)
+	}
+	span.decide(occurrences.Duplicate, err)
+	return occurrences, err
+}
+
+func (d *Deduper) isDuplicateN(ctx context.Context, entity any, strategy HashStrategy, limit int64, window time.Duration) (Occurrences, error) {
+	d.count(ctx, OpIsDuplicateN, MetricCheck, 1)
+
+	dedupHash, err := d.Hash(ctx, entity, strategy, false)
+	if err != nil {
+		return Occurrences{}, err
+	}
+
+	count, remaining, err := Incr(ctx, d.storage, d.taggedKey(ctx, counterTag, dedupHash), window)
+	if err != nil {
+		d.count(ctx, OpIsDuplicateN, MetricStorageError, 1)
+		return Occurrences{}, errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
+	}
+
+	occurrences := Occurrences{Duplicate: count > limit, Count: count, Remaining: remaining}
+	if occurrences.Duplicate {
+		d.count(ctx, OpIsDuplicateN, MetricDuplicate, 1)
+	}
+	return occurrences, nil
+}
@@ -0,0 +1,139 @@
+package dedup
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDuplicateN(t *testing.T) {
+	ctx := context.Background()
+	handler := func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }
+
+	t.Run("Up to limit occurrences per window", func(t *testing.T) {
+		clock := newFakeClock()
+		deduper := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now}))
+
+		for i := int64(1); i <= 3; i++ {
+			occurrences, err := deduper.IsDuplicateN(ctx, "alert-1", DefaultHashStrategy(), 3, time.Hour)
+			assert.NoError(t, err)
+			assert.Equal(t, Occurrences{Count: i, Remaining: time.Hour}, occurrences)
+		}
+
+		// Later occurrences do not extend the window
+		clock.Advance(20 * time.Minute)
+		occurrences, err := deduper.IsDuplicateN(ctx, "alert-1", DefaultHashStrategy(), 3, time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, Occurrences{Duplicate: true, Count: 4, Remaining: 40 * time.Minute}, occurrences)
+
+		// Counters do not collide with IsDuplicate keys
+		isDuplicate, err := deduper.IsDuplicate(ctx, "alert-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		// A new window starts once the previous one ended
+		clock.Advance(41 * time.Minute)
+		occurrences, err = deduper.IsDuplicateN(ctx, "alert-1", DefaultHashStrategy(), 3, time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, Occurrences{Count: 1, Remaining: time.Hour}, occurrences)
+	})
+
+	t.Run("Redis storage uses INCR and PEXPIRE", func(t *testing.T) {
+		mr, err := miniredis.Run()
+		assert.NoError(t, err)
+		defer mr.Close()
+
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer client.Close()
+
+		deduper := New(handler, NewRedisStorage(ctx, client), WithPrefix("alerts:"))
+
+		for i := 0; i < 3; i++ {
+			occurrences, err := deduper.IsDuplicateN(ctx, "alert-1", DefaultHashStrategy(), 2, time.Hour)
+			assert.NoError(t, err)
+			assert.Equal(t, i >= 2, occurrences.Duplicate)
+			assert.Equal(t, int64(i+1), occurrences.Count)
+			assert.Equal(t, time.Hour, occurrences.Remaining)
+		}
+
+		val, err := mr.Get("alerts:\x00n:alert-1")
+		assert.NoError(t, err)
+		assert.Equal(t, "3", val)
+		assert.Equal(t, time.Hour, mr.TTL("alerts:\x00n:alert-1"))
+
+		mr.FastForward(time.Hour)
+		occurrences, err := deduper.IsDuplicateN(ctx, "alert-1", DefaultHashStrategy(), 2, time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), occurrences.Count)
+	})
+
+	t.Run("Counters never collide with entity keys", func(t *testing.T) {
+		deduper := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}))
+
+		_, err := deduper.IsDuplicateN(ctx, "abc", DefaultHashStrategy(), 1, time.Hour)
+		assert.NoError(t, err)
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, "n:abc", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+
+	t.Run("Concurrent occurrences are counted atomically", func(t *testing.T) {
+		deduper := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}))
+
+		var allowed atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				occurrences, err := deduper.IsDuplicateN(ctx, "alert-1", DefaultHashStrategy(), 3, time.Hour)
+				assert.NoError(t, err)
+				if !occurrences.Duplicate {
+					allowed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(3), allowed.Load())
+	})
+
+	t.Run("Fallback without CounterStorage", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := &countingStorage{Storage: NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})}
+		deduper := New(handler, storage)
+
+		_, err := deduper.IsDuplicateN(ctx, "alert-1", DefaultHashStrategy(), 1, time.Hour)
+		assert.NoError(t, err)
+
+		clock.Advance(10 * time.Minute)
+		occurrences, err := deduper.IsDuplicateN(ctx, "alert-1", DefaultHashStrategy(), 1, time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, Occurrences{Duplicate: true, Count: 2, Remaining: 50 * time.Minute}, occurrences)
+	})
+
+	t.Run("Non-counter values", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		deduper := New(handler, storage)
+		assert.NoError(t, storage.SetEX(ctx, []byte("dedup:string:\x00n:alert-1"), []byte("not-a-number"), time.Hour))
+
+		_, err := deduper.IsDuplicateN(ctx, "alert-1", DefaultHashStrategy(), 3, time.Hour)
+		assert.Error(t, err)
+		assert.True(t, hasCode(err, DedupInvalidCounterErrorCode))
+	})
+
+	t.Run("No window", func(t *testing.T) {
+		deduper := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}))
+
+		occurrences, err := deduper.IsDuplicateN(ctx, "alert-1", DefaultHashStrategy(), 3, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, Occurrences{Count: 1, Remaining: -1}, occurrences)
+	})
+}
@@ -40,22 +40,40 @@ type hashHandler = func(ctx context.Context, entity any) ([]byte, error)
 type matchHandler = func(ctx context.Context, inputEntity any, storageEntity any) (bool, error)
 type serializeHandler = func(ctx context.Context, inputEntity any) (string, error)
 
+// Serializer turns an entity into the value stored under its key without a string copy,
+// see WithSerializer and the serialize package for ready-made ones
+type Serializer = func(ctx context.Context, entity any) ([]byte, error)
+
 // Storage defines the interface for storage operations required by Deduper
 type Storage interface {
 	Get(ctx context.Context, key []byte) ([]byte, error)
 	TTL(ctx context.Context, key []byte) (time.Duration, error)
 	SetEX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error
 	Exists(ctx context.Context, key []byte) (bool, error)
+	Del(ctx context.Context, key []byte) (bool, error)
 }
 
 type Deduper struct {
-	handler    hashHandler
-	storage    Storage
-	prefix     []byte
-	logger     logger.Interface
-	hasher     func() hash.Hash
-	matcher    matchHandler
-	serializer serializeHandler
+	handler     hashHandler
+	storage     Storage
+	prefix      []byte
+	logger      logger.Interface
+	hasher      func() hash.Hash
+	matcher     matchHandler
+	serializer  Serializer
+	strategy    HashStrategy
+	ttl         time.Duration
+	sliding     bool
+	hashTag     bool
+	metrics     Metrics
+	tracer      Tracer
+	similarity  SimilarityConfig
+	keyEncoding KeyEncoding
+	legacyKeys  bool
+	version     int
+	migration   *migration
+	scope       string
+	scopes      map[string]ScopeDefaults
 }
 
 func NewDeduper[T any](
@@ -73,27 +91,56 @@ func NewDeduper[T any](
 	}
 
 	return &Deduper{
-		handler: func(ctx context.Context, entity any) ([]byte, error) {
-			if entity == nil {
-				return nil, errors.New("entity is nil", DedupEntityNilErrorCode)
-			}
-
-			_, ok := entity.(T)
-			if !ok {
-				return nil, errors.New("entity is not of type '%s'", nameOf[T](), DedupEntityTypeMismatchErrorCode)
-			}
-
-			return handler(ctx, entity.(T))
-		},
+		handler:    KeyHandler(handler),
 		storage:    storage,
 		prefix:     prefix,
 		logger:     logger,
 		hasher:     hasher,
 		matcher:    matcher,
-		serializer: serializer,
+		serializer: bytesSerializer(serializer),
+		strategy:   DefaultHashStrategy(),
+		ttl:        DefaultTTL,
+	}
+}
+
+// KeyHandler adapts a typed key handler to any entity, failing on nil entities and other types
+func KeyHandler[T any](handler func(ctx context.Context, t T) ([]byte, error)) func(ctx context.Context, entity any) ([]byte, error) {
+	return func(ctx context.Context, entity any) ([]byte, error) {
+		if entity == nil {
+			return nil, errors.New("entity is nil", DedupEntityNilErrorCode)
+		}
+
+		_, ok := entity.(T)
+		if !ok {
+			return nil, errors.New("entity is not of type '%s'", nameOf[T](), DedupEntityTypeMismatchErrorCode)
+		}
+
+		return handler(ctx, entity.(T))
 	}
 }
 
+// Prefix returns the key prefix, with the version of WithVersion and the hash tag of WithHashTag
+func (d *Deduper) Prefix() string {
+	return string(d.prefix)
+}
+
+// DefaultStrategy returns the strategy configured with WithDefaultStrategy
+func (d *Deduper) DefaultStrategy() HashStrategy {
+	return d.strategy
+}
+
+// DefaultTTL returns the expiration configured with WithDefaultTTL
+func (d *Deduper) DefaultTTL() time.Duration {
+	return d.ttl
+}
+
+// Seen is IsDuplicate with the default strategy, storing new entities for the default TTL,
+// both those of the scope of ctx when it has ScopeDefaults
+func (d *Deduper) Seen(ctx context.Context, entity any) (bool, error) {
+	strategy, ttl := d.defaults(ctx)
+	return d.IsDuplicate(ctx, entity, strategy, ttl)
+}
+
 func (d *Deduper) Hash(ctx context.Context, entity any, strategy HashStrategy, isValue bool) ([]byte, error) {
 	input, err := d.handler(ctx, entity)
 	if err != nil {
@@ -107,69 +154,241 @@ func (d *Deduper) Hash(ctx context.Context, entity any, strategy HashStrategy, i
 		threshold = strategy.ValThreshold
 	}
 
-	if mode == NeverHash || (mode == AutoSmart && len(input) <= threshold) {
-		return input, nil
+	if !shouldHash(mode, threshold, len(input)) {
+		if isValue {
+			return input, nil
+		}
+		d.spanOf(ctx).keyMode(false)
+		return encodeKey(d.keyEncoding, input, false), nil
 	}
 
 	h := d.hasher()
 	h.Write(input)
-	return h.Sum(nil), nil
+	if isValue {
+		return h.Sum(nil), nil
+	}
+	d.spanOf(ctx).keyMode(true)
+	return encodeKey(d.keyEncoding, h.Sum(nil), true), nil
+}
+
+// shouldHash reports whether an input of size bytes is hashed under mode
+func shouldHash(mode HashMode, threshold int, size int) bool {
+	return mode == AlwaysHash || (mode == AutoSmart && size > threshold)
+}
+
+// Key returns the storage key of the entity, the one the other operations read and write
+func (d *Deduper) Key(ctx context.Context, entity any, strategy HashStrategy) ([]byte, error) {
+	dedupHash, err := d.Hash(ctx, entity, strategy, false)
+	if err != nil {
+		return nil, err
+	}
+	return d.buildKey(ctx, dedupHash), nil
+}
+
+func (d *Deduper) buildKey(ctx context.Context, hash []byte) []byte {
+	// copy so concurrent callers never share the prefix backing array
+	prefix := d.keyPrefix(ctx)
+	key := make([]byte, 0, len(prefix)+len(hash))
+	key = append(key, prefix...)
+	return append(key, hash...)
 }
 
-func (d *Deduper) buildKey(hash []byte) []byte {
-	return append(d.prefix, hash...)
+// Reserved tags start the sub-keys a Deduper keeps next to its entity keys, after the prefix
+// and scope segment, and the scope segment itself. Text keys never start with a NUL byte, so
+// no sub-key shares its key with an entity key of IsDuplicate and Store, nor with another kind
+// of sub-key. Under LegacyKeys this only holds for text keys: a binary handler whose output
+// starts with 0x00 can still collide with a sub-key. The tagged encodings never start with one.
+const (
+	resultTag      = "\x00r:"
+	counterTag     = "\x00n:"
+	scopeTag       = "\x00s:"
+	fingerprintTag = "\x00f:"
+	bucketTag      = "\x00l:"
+)
+
+// taggedKey builds the sub-key "<prefix><tag><suffix>", see the reserved tags
+func (d *Deduper) taggedKey(ctx context.Context, tag string, suffix []byte) []byte {
+	prefix := d.keyPrefix(ctx)
+	key := make([]byte, 0, len(prefix)+len(tag)+len(suffix))
+	key = append(key, prefix...)
+	key = append(key, tag...)
+	return append(key, suffix...)
 }
 
+// IsDuplicate reports whether the entity key exists. With storeIfNot a new entity is stored
+// with that expiration, first writer wins when the storage implements AtomicStorage. Failing
+// to store it is logged rather than returned, only failing to check the key is an error.
 func (d *Deduper) IsDuplicate(ctx context.Context, entity any, strategy HashStrategy, storeIfNot ...time.Duration) (bool, error) {
+	ctx, span := d.startSpan(ctx, OpIsDuplicate)
+	isDuplicate, err := d.isDuplicate(ctx, entity, strategy, storeIfNot...)
+	span.decide(isDuplicate, err)
+	return isDuplicate, err
+}
+
+func (d *Deduper) isDuplicate(ctx context.Context, entity any, strategy HashStrategy, storeIfNot ...time.Duration) (bool, error) {
+	d.count(ctx, OpIsDuplicate, MetricCheck, 1)
+
 	dedupHash, err := d.Hash(ctx, entity, strategy, false)
 	if err != nil {
 		return false, err
 	}
-	key := d.buildKey(dedupHash)
+	key := d.buildKey(ctx, dedupHash)
+
+	legacy, err := d.legacyExists(ctx, dedupHash)
+	if err != nil {
+		d.count(ctx, OpIsDuplicate, MetricStorageError, 1)
+		return false, err
+	}
+	if legacy {
+		d.count(ctx, OpIsDuplicate, MetricDuplicate, 1)
+		return true, nil
+	}
+
+	// first writer wins when the storage can claim the key atomically
+	store := len(storeIfNot) > 0
+	if supports[AtomicStorage](d.storage) && store {
+		claimed, err := d.claim(ctx, key, entity, strategy, storeIfNot[0])
+		if err == nil {
+			return d.claimed(ctx, key, entity, claimed, storeIfNot...)
+		}
+
+		// like a failed store below, a failed claim is logged and the key is only checked
+		d.fail(ctx, OpIsDuplicate, err)
+		d.logger.With("error", err).Error("failed to store hash at IsDuplicate; %s", err.Error())
+		store = false
+	}
 
 	exists, err := d.storage.Exists(ctx, key)
 	if err != nil {
+		d.count(ctx, OpIsDuplicate, MetricStorageError, 1)
 		return false, errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
 	}
+	if !exists {
+		previous, err := d.migrated(ctx, entity, key)
+		if err != nil {
+			d.fail(ctx, OpIsDuplicate, err)
+			return false, err
+		}
+		exists = previous != nil
+	}
 
-	if !exists && len(storeIfNot) > 0 {
-		_, _, err = d.Store(ctx, entity, strategy, storeIfNot[0])
+	if exists {
+		d.count(ctx, OpIsDuplicate, MetricDuplicate, 1)
+		d.slide(ctx, key, storeIfNot...)
+	}
+
+	if !exists && store {
+		_, _, err = d.store(ctx, dedupHash, entity, strategy, storeIfNot[0])
 		if err != nil {
+			d.fail(ctx, OpIsDuplicate, err)
 			d.logger.With("error", err).Error("failed to store hash at IsDuplicate; %s", err.Error())
+		} else {
+			d.count(ctx, OpIsDuplicate, MetricStore, 1)
 		}
 	}
 
 	return exists, nil
 }
 
+// claimed finishes an IsDuplicate whose claim of key went through, reporting a duplicate
+// when the key was taken or the entity is found under an earlier key of WithMigration
+func (d *Deduper) claimed(ctx context.Context, key []byte, entity any, claimed bool, storeIfNot ...time.Duration) (bool, error) {
+	if claimed {
+		previous, err := d.migrated(ctx, entity, key)
+		if err != nil {
+			d.fail(ctx, OpIsDuplicate, err)
+			return false, err
+		}
+		claimed = previous == nil
+	}
+
+	if claimed {
+		d.count(ctx, OpIsDuplicate, MetricStore, 1)
+	} else {
+		d.count(ctx, OpIsDuplicate, MetricDuplicate, 1)
+		d.slide(ctx, key, storeIfNot...)
+	}
+	return !claimed, nil
+}
+
+// slide refreshes the expiration of a duplicate key when WithSlidingWindow is set
+func (d *Deduper) slide(ctx context.Context, key []byte, expiration ...time.Duration) {
+	if !d.sliding {
+		return
+	}
+
+	_, ttl := d.defaults(ctx)
+	if len(expiration) > 0 {
+		ttl = expiration[0]
+	}
+
+	_, err := Expire(ctx, d.storage, key, ttl)
+	if err != nil {
+		d.count(ctx, OpIsDuplicate, MetricStorageError, 1)
+		d.logger.With("error", err).Error("failed to refresh expiration at IsDuplicate; %s", err.Error())
+	}
+}
+
 func (d *Deduper) IsValueDuplicate(ctx context.Context, entity any, strategy HashStrategy, storeIfNot ...time.Duration) (bool, error) {
+	ctx, span := d.startSpan(ctx, OpIsValueDuplicate)
+	isDuplicate, err := d.isValueDuplicate(ctx, entity, strategy, storeIfNot...)
+	span.decide(isDuplicate, err)
+	return isDuplicate, err
+}
+
+func (d *Deduper) isValueDuplicate(ctx context.Context, entity any, strategy HashStrategy, storeIfNot ...time.Duration) (bool, error) {
+	d.count(ctx, OpIsValueDuplicate, MetricCheck, 1)
+
 	dedupHash, err := d.Hash(ctx, entity, strategy, false)
 	if err != nil {
 		return false, err
 	}
-	key := d.buildKey(dedupHash)
+	key := d.buildKey(ctx, dedupHash)
+
+	legacy, err := d.legacyValue(ctx, dedupHash)
+	if err != nil {
+		d.count(ctx, OpIsValueDuplicate, MetricStorageError, 1)
+		return false, err
+	}
+
+	// Without a matcher the comparison is a plain byte compare, so it can run inside the storage
+	if supports[CompareAndSwapStorage](d.storage) && d.matcher == nil && legacy == nil && !d.migrating() {
+		matched, err := d.compareAndSwap(ctx, key, entity, strategy, storeIfNot...)
+		if err != nil {
+			d.fail(ctx, OpIsValueDuplicate, err)
+		}
+		return matched, err
+	}
 
 	existing, err := d.storage.Get(ctx, key)
 	if err != nil {
+		d.count(ctx, OpIsValueDuplicate, MetricStorageError, 1)
 		return false, errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
 	}
+	if IsEmpty(existing) && legacy != nil {
+		existing = legacy
+	}
+	if IsEmpty(existing) {
+		existing, err = d.migrated(ctx, entity, key)
+		if err != nil {
+			d.fail(ctx, OpIsValueDuplicate, err)
+			return false, err
+		}
+	}
 
 	if IsEmpty(existing) {
 		if len(storeIfNot) > 0 {
-			_, _, err = d.store(ctx, dedupHash, entity, strategy, storeIfNot[0])
-			if err != nil {
-				d.logger.With("error", err).Error("failed to store dedupHash at IsDuplicate; %s", err.Error())
-			}
+			d.storeValue(ctx, dedupHash, entity, strategy, storeIfNot[0])
 		}
 		return false, nil
 	}
 
 	// Serialize the input entity
-	serStr, err := d.serializer(ctx, entity)
+	ser, err := d.serializer(ctx, entity)
 	if err != nil {
+		d.count(ctx, OpIsValueDuplicate, MetricSerializerError, 1)
 		return false, errors.Wrap(err, "failed to serialize entity", DedupStorageErrorCode)
 	}
-	ser := []byte(serStr)
 
 	// Apply the same hashing rules as in store method
 	if d.matcher == nil && strategy.ValueHashMode != NeverHash && (strategy.ValueHashMode == AlwaysHash || len(ser) > strategy.ValThreshold) {
@@ -179,13 +398,13 @@ func (d *Deduper) IsValueDuplicate(ctx context.Context, entity any, strategy Has
 
 		// Direct comparison of hashed values
 		isDuplicate := bytes.Equal(ser, existing)
+		if isDuplicate {
+			d.count(ctx, OpIsValueDuplicate, MetricDuplicate, 1)
+		}
 
 		// Store if not duplicate and storeIfNot is provided
 		if !isDuplicate && len(storeIfNot) > 0 {
-			_, _, err = d.store(ctx, dedupHash, entity, strategy, storeIfNot[0])
-			if err != nil {
-				d.logger.With("error", err).Error("failed to store dedupHash at IsValueDuplicate; %s", err.Error())
-			}
+			d.storeValue(ctx, dedupHash, entity, strategy, storeIfNot[0])
 		}
 
 		return isDuplicate, nil
@@ -195,15 +414,21 @@ func (d *Deduper) IsValueDuplicate(ctx context.Context, entity any, strategy Has
 	if d.matcher != nil {
 		match, err := d.matcher(ctx, entity, string(existing))
 		if err != nil {
+			d.count(ctx, OpIsValueDuplicate, MetricMatcherError, 1)
 			return false, errors.Wrap(err, "failed to match Value at IsDuplicate; %s", err.Error())
 		}
+		if match {
+			d.count(ctx, OpIsValueDuplicate, MetricDuplicate, 1)
+		}
 
 		if !match && len(storeIfNot) > 0 && (len(storeIfNot) < 2 || storeIfNot[1] == 1) {
 			_, _, err = d.store(ctx, dedupHash, entity, strategy, storeIfNot[0])
 			if err != nil {
+				d.fail(ctx, OpIsValueDuplicate, err)
 				d.logger.With("error", err).Error("failed to update dedupHash at IsDuplicate; %s", err.Error())
 				return match, err
 			}
+			d.count(ctx, OpIsValueDuplicate, MetricStore, 1)
 		}
 
 		return match, nil
@@ -211,33 +436,100 @@ func (d *Deduper) IsValueDuplicate(ctx context.Context, entity any, strategy Has
 
 	// Direct comparison of serialized values
 	isDuplicate := bytes.Equal(ser, existing)
+	if isDuplicate {
+		d.count(ctx, OpIsValueDuplicate, MetricDuplicate, 1)
+	}
 
 	// Store if not duplicate and storeIfNot is provided
 	if !isDuplicate && len(storeIfNot) > 0 {
-		_, _, err = d.store(ctx, dedupHash, entity, strategy, storeIfNot[0])
-		if err != nil {
-			d.logger.With("error", err).Error("failed to store dedupHash at IsValueDuplicate; %s", err.Error())
-		}
+		d.storeValue(ctx, dedupHash, entity, strategy, storeIfNot[0])
 	}
 
 	return isDuplicate, nil
 }
 
+// storeValue stores the entity for IsValueDuplicate, where store failures are logged rather than returned
+func (d *Deduper) storeValue(ctx context.Context, dedupHash []byte, entity any, strategy HashStrategy, expiration time.Duration) {
+	_, _, err := d.store(ctx, dedupHash, entity, strategy, expiration)
+	if err != nil {
+		d.fail(ctx, OpIsValueDuplicate, err)
+		d.logger.With("error", err).Error("failed to store dedupHash at IsValueDuplicate; %s", err.Error())
+		return
+	}
+	d.count(ctx, OpIsValueDuplicate, MetricStore, 1)
+}
+
 func (d *Deduper) Store(ctx context.Context, entity any, strategy HashStrategy, expiration time.Duration) ([]byte, []byte, error) {
+	ctx, span := d.startSpan(ctx, OpStore)
+	dedupHash, key, err := d.storeEntity(ctx, entity, strategy, expiration)
+	span.end(err)
+	return dedupHash, key, err
+}
+
+func (d *Deduper) storeEntity(ctx context.Context, entity any, strategy HashStrategy, expiration time.Duration) ([]byte, []byte, error) {
 	dedupHash, err := d.Hash(ctx, entity, strategy, false)
 	if err != nil {
 		return nil, nil, err
 	}
-	return d.store(ctx, dedupHash, entity, strategy, expiration)
+
+	dedupHash, key, err := d.store(ctx, dedupHash, entity, strategy, expiration)
+	if err != nil {
+		d.fail(ctx, OpStore, err)
+		return nil, nil, err
+	}
+	d.count(ctx, OpStore, MetricStore, 1)
+	return dedupHash, key, nil
 }
 
 func (d *Deduper) store(ctx context.Context, dedupHash []byte, entity any, strategy HashStrategy, expiration time.Duration) ([]byte, []byte, error) {
-	key := d.buildKey(dedupHash)
-	serStr, err := d.serializer(ctx, entity)
+	key := d.buildKey(ctx, dedupHash)
+	ser, err := d.value(ctx, entity, strategy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = d.storage.SetEX(ctx, key, ser, expiration)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to store dedupHash; %s", err.Error(), DedupStorageErrorCode)
+	}
+
+	return dedupHash, key, nil
+}
+
+// compareAndSwap is the atomic IsValueDuplicate path: compare the stored value and,
+// with storeIfNot, replace it on mismatch in a single storage call
+func (d *Deduper) compareAndSwap(ctx context.Context, key []byte, entity any, strategy HashStrategy, storeIfNot ...time.Duration) (bool, error) {
+	ser, err := d.value(ctx, entity, strategy)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to serialize entity", DedupStorageErrorCode)
+		return false, err
+	}
+
+	expiration := d.ttl
+	if len(storeIfNot) > 0 {
+		expiration = storeIfNot[0]
+	}
+
+	matched, replaced, err := CompareAndSwap(ctx, d.storage, key, ser, len(storeIfNot) > 0, expiration)
+	if err != nil {
+		return false, errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
+	}
+
+	if matched {
+		d.count(ctx, OpIsValueDuplicate, MetricDuplicate, 1)
+	}
+	if replaced {
+		d.count(ctx, OpIsValueDuplicate, MetricStore, 1)
+	}
+	return matched, nil
+}
+
+// value serializes the entity into the bytes kept under its key
+func (d *Deduper) value(ctx context.Context, entity any, strategy HashStrategy) ([]byte, error) {
+	ser, err := d.serializer(ctx, entity)
+	if err != nil {
+		// counted as a serializer error by fail, though the code stays the one callers match on
+		return nil, errors.Wrap(classifiedError{err, MetricSerializerError}, "failed to serialize entity", DedupStorageErrorCode)
 	}
-	ser := []byte(serStr)
 
 	// Don't hash the serialized entity if we have a matcher function
 	// This ensures the matcher can properly compare the stored entity with input entities
@@ -247,29 +539,155 @@ func (d *Deduper) store(ctx context.Context, dedupHash []byte, entity any, strat
 		ser = []byte(hex.EncodeToString(h.Sum(nil)))
 	}
 
-	err = d.storage.SetEX(ctx, key, ser, expiration)
+	return ser, nil
+}
+
+// Claim atomically marks the entity as seen, first writer wins.
+// Returns true when this caller won the claim and should process the entity.
+// Storages without AtomicStorage fall back to a non-atomic Exists + SetEX.
+func (d *Deduper) Claim(ctx context.Context, entity any, strategy HashStrategy, expiration time.Duration) (bool, error) {
+	d.count(ctx, OpClaim, MetricCheck, 1)
+
+	dedupHash, err := d.Hash(ctx, entity, strategy, false)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "failed to store dedupHash; %s", err.Error(), DedupStorageErrorCode)
+		return false, err
 	}
 
-	return dedupHash, key, nil
+	legacy, err := d.legacyExists(ctx, dedupHash)
+	if err != nil {
+		d.fail(ctx, OpClaim, err)
+		return false, err
+	}
+	if legacy {
+		d.count(ctx, OpClaim, MetricDuplicate, 1)
+		return false, nil
+	}
+
+	claimed, err := d.claimCurrent(ctx, d.buildKey(ctx, dedupHash), entity, strategy, expiration)
+	switch {
+	case err != nil:
+		d.fail(ctx, OpClaim, err)
+	case claimed:
+		d.count(ctx, OpClaim, MetricStore, 1)
+	default:
+		d.count(ctx, OpClaim, MetricDuplicate, 1)
+	}
+	return claimed, err
+}
+
+// claimCurrent claims key unless the entity is found under an earlier key of WithMigration
+func (d *Deduper) claimCurrent(ctx context.Context, key []byte, entity any, strategy HashStrategy, expiration time.Duration) (bool, error) {
+	claimed, err := d.claim(ctx, key, entity, strategy, expiration)
+	if err != nil || !claimed {
+		return claimed, err
+	}
+
+	previous, err := d.migrated(ctx, entity, key)
+	if err != nil {
+		return false, err
+	}
+	return previous == nil, nil
+}
+
+func (d *Deduper) claim(ctx context.Context, key []byte, entity any, strategy HashStrategy, expiration time.Duration) (bool, error) {
+	ser, err := d.value(ctx, entity, strategy)
+	if err != nil {
+		return false, err
+	}
+
+	claimed, err := SetNX(ctx, d.storage, key, ser, expiration)
+	if err != nil {
+		return false, errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
+	}
+
+	return claimed, nil
 }
 
 func (d *Deduper) StoreHash(ctx context.Context, hash []byte, expiration time.Duration) ([]byte, error) {
-	key := d.buildKey(hash)
+	ctx, span := d.startSpan(ctx, OpStoreHash)
+	key, err := d.storeHash(ctx, hash, expiration)
+	span.end(err)
+	return key, err
+}
+
+func (d *Deduper) storeHash(ctx context.Context, hash []byte, expiration time.Duration) ([]byte, error) {
+	key := d.buildKey(ctx, hash)
 	err := d.storage.SetEX(ctx, key, []byte("1"), expiration)
 	if err != nil {
+		d.count(ctx, OpStore, MetricStorageError, 1)
 		return nil, errors.Wrap(err, "failed to store dedupHash; %s", err.Error(), DedupStorageErrorCode)
 	}
+	d.count(ctx, OpStore, MetricStore, 1)
 	return key, nil
 }
 
+// Forget removes the entity key so a later retry is not treated as a duplicate
+func (d *Deduper) Forget(ctx context.Context, entity any, strategy HashStrategy) error {
+	dedupHash, err := d.Hash(ctx, entity, strategy, false)
+	if err != nil {
+		return err
+	}
+
+	err = d.ForgetHash(ctx, dedupHash)
+	forgotten, migratedErr := d.forgetMigrated(ctx, entity)
+	if migratedErr != nil {
+		return migratedErr
+	}
+	if _, missing := errors.Has(err, DedupMissingKeyErrorCode); forgotten && missing {
+		return nil
+	}
+	return err
+}
+
+// ForgetHash removes the key built from hash, as stored by StoreHash or Store,
+// its result of StoreResultHash and its legacy key with WithLegacyKeyFallback
+func (d *Deduper) ForgetHash(ctx context.Context, hash []byte) error {
+	key := d.buildKey(ctx, hash)
+	deleted, err := d.storage.Del(ctx, key)
+	if err != nil {
+		d.count(ctx, OpForget, MetricStorageError, 1)
+		return errors.Wrap(err, "storage error for key; %s", err.Error(), DedupStorageErrorCode)
+	}
+	// a retry must not replay the result of the attempt being forgotten
+	_, err = d.storage.Del(ctx, d.taggedKey(ctx, resultTag, hash))
+	if err != nil {
+		d.count(ctx, OpForget, MetricStorageError, 1)
+		return errors.Wrap(err, "storage error for result key; %s", err.Error(), DedupStorageErrorCode)
+	}
+	if legacy := d.legacyHash(hash); legacy != nil {
+		deletedLegacy, err := d.storage.Del(ctx, d.buildKey(ctx, legacy))
+		if err != nil {
+			d.count(ctx, OpForget, MetricStorageError, 1)
+			return errors.Wrap(err, "storage error for legacy key; %s", err.Error(), DedupStorageErrorCode)
+		}
+		deleted = deleted || deletedLegacy
+	}
+	if !deleted {
+		return errors.New("key does not exist", DedupMissingKeyErrorCode)
+	}
+	return nil
+}
+
 func (d *Deduper) TTL(ctx context.Context, hash []byte) (time.Duration, error) {
-	key := d.buildKey(hash)
+	ctx, span := d.startSpan(ctx, OpTTL)
+	ttl, err := d.keyTTL(ctx, hash)
+	span.end(err)
+	return ttl, err
+}
+
+func (d *Deduper) keyTTL(ctx context.Context, hash []byte) (time.Duration, error) {
+	key := d.buildKey(ctx, hash)
 	ttl, err := d.storage.TTL(ctx, key)
 	if err != nil {
 		return 0, errors.Wrap(err, "storage error for key; %s", err.Error(), DedupStorageErrorCode)
 	}
+	// storages report a missing key as 0 or -2
+	if legacy := d.legacyHash(hash); (ttl == 0 || ttl == -2) && legacy != nil {
+		ttl, err = d.storage.TTL(ctx, d.buildKey(ctx, legacy))
+		if err != nil {
+			return 0, errors.Wrap(err, "storage error for legacy key; %s", err.Error(), DedupStorageErrorCode)
+		}
+	}
 	if ttl == 0 {
 		return 0, errors.New("key does not exist", DedupMissingKeyErrorCode)
 	}
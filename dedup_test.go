@@ -4,8 +4,11 @@ import (
 	"context"
 	"crypto/sha1"
 	"encoding/json"
+	"github.com/pixie-sh/errors-go"
 	"github.com/pixie-sh/logger-go/logger"
 	"hash"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -129,6 +132,108 @@ func TestRedisStorage(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, time.Duration(-2), ttl)
 	})
+
+	t.Run("Del", func(t *testing.T) {
+		// Clean up before test
+		mr.FlushAll()
+
+		// Deleting a missing key reports false
+		deleted, err := storage.Del(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.False(t, deleted)
+
+		err = mr.Set("test-key", "value")
+		assert.NoError(t, err)
+
+		deleted, err = storage.Del(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.True(t, deleted)
+		assert.False(t, mr.Exists("test-key"))
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		// Clean up before test
+		mr.FlushAll()
+
+		// Missing keys are reported
+		ok, err := storage.Expire(ctx, []byte("test-key"), 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+
+		err = storage.SetEX(ctx, []byte("test-key"), []byte("value"), 2*time.Second)
+		assert.NoError(t, err)
+
+		ok, err = storage.Expire(ctx, []byte("test-key"), 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 10*time.Second, mr.TTL("test-key"))
+	})
+
+	t.Run("SetNX", func(t *testing.T) {
+		// Clean up before test
+		mr.FlushAll()
+
+		// First writer wins
+		ok, err := storage.SetNX(ctx, []byte("test-key"), []byte("first"), 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		// Second writer loses and does not overwrite
+		ok, err = storage.SetNX(ctx, []byte("test-key"), []byte("second"), 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+
+		val, err := storage.Get(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("first"), val)
+
+		// Expiration is applied
+		ttl, err := storage.TTL(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.True(t, ttl > 0 && ttl <= 10*time.Second)
+
+		// Key can be claimed again after expiration
+		mr.FastForward(11 * time.Second)
+		ok, err = storage.SetNX(ctx, []byte("test-key"), []byte("third"), 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("CompareAndSwap", func(t *testing.T) {
+		// Clean up before test
+		mr.FlushAll()
+
+		// Missing key without replace is a mismatch and nothing is written
+		matched, replaced, err := storage.CompareAndSwap(ctx, []byte("test-key"), []byte("first"), false)
+		assert.NoError(t, err)
+		assert.False(t, matched)
+		assert.False(t, replaced)
+		assert.False(t, mr.Exists("test-key"))
+
+		// Missing key with replace stores the value with its expiration
+		matched, replaced, err = storage.CompareAndSwap(ctx, []byte("test-key"), []byte("first"), true, 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, matched)
+		assert.True(t, replaced)
+		assert.Equal(t, 10*time.Second, mr.TTL("test-key"))
+
+		// Equal value matches and is left alone
+		matched, replaced, err = storage.CompareAndSwap(ctx, []byte("test-key"), []byte("first"), true, 20*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, matched)
+		assert.False(t, replaced)
+		assert.Equal(t, 10*time.Second, mr.TTL("test-key"))
+
+		// Different value is replaced
+		matched, replaced, err = storage.CompareAndSwap(ctx, []byte("test-key"), []byte("second"), true, 20*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, matched)
+		assert.True(t, replaced)
+
+		val, err := storage.Get(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("second"), val)
+	})
 }
 
 // MockLogger implements the LoggerInterface
@@ -191,6 +296,7 @@ type MockStorage struct {
 	getFunc    func(ctx context.Context, key []byte) ([]byte, error)
 	setExFunc  func(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error
 	ttlFunc    func(ctx context.Context, key []byte) (time.Duration, error)
+	delFunc    func(ctx context.Context, key []byte) (bool, error)
 }
 
 func (m *MockStorage) Exists(ctx context.Context, key []byte) (bool, error) {
@@ -209,6 +315,23 @@ func (m *MockStorage) TTL(ctx context.Context, key []byte) (time.Duration, error
 	return m.ttlFunc(ctx, key)
 }
 
+func (m *MockStorage) Del(ctx context.Context, key []byte) (bool, error) {
+	if m.delFunc == nil {
+		return false, nil
+	}
+	return m.delFunc(ctx, key)
+}
+
+// atomicMockStorage is a MockStorage implementing AtomicStorage
+type atomicMockStorage struct {
+	*MockStorage
+	setNXFunc func(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) (bool, error)
+}
+
+func (m *atomicMockStorage) SetNX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) (bool, error) {
+	return m.setNXFunc(ctx, key, value, expiration...)
+}
+
 // TestEntity represents a sample entity for deduplication testing
 type TestEntity struct {
 	ID   string
@@ -487,6 +610,104 @@ func TestDeduperComprehensive(t *testing.T) {
 		assert.False(t, isDuplicate)
 	})
 
+	t.Run("IsDuplicate with storeIfNot logs failed atomic claims", func(t *testing.T) {
+		exists := false
+		existsErr := error(nil)
+		mockStorage := &atomicMockStorage{
+			MockStorage: &MockStorage{
+				existsFunc: func(ctx context.Context, key []byte) (bool, error) {
+					return exists, existsErr
+				},
+			},
+			setNXFunc: func(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) (bool, error) {
+				return false, assert.AnError
+			},
+		}
+
+		deduper := NewDeduper(hashHandler, mockStorage, log, func() hash.Hash { return sha1.New() }, matchHandler, serializer)
+		entity := TestEntity{ID: "123", Name: "Test"}
+
+		// like the non-atomic path, a failed store is logged and the key is only checked
+		isDuplicate, err := deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		exists = true
+		isDuplicate, err = deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		// failing to check the key is still an error
+		existsErr = assert.AnError
+		_, err = deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.True(t, hasCode(err, DedupStorageErrorCode))
+
+		// a serializer failure fails the claim before it reaches the storage, it is logged too
+		failing := func(ctx context.Context, entity any) (string, error) { return "", assert.AnError }
+		deduper = NewDeduper(hashHandler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}), log, func() hash.Hash { return sha1.New() }, matchHandler, failing)
+		isDuplicate, err = deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+
+	t.Run("Claim without AtomicStorage falls back to Exists and SetEX", func(t *testing.T) {
+		stored := map[string][]byte{}
+		mockStorage := &MockStorage{
+			existsFunc: func(ctx context.Context, key []byte) (bool, error) {
+				_, ok := stored[string(key)]
+				return ok, nil
+			},
+			getFunc: func(ctx context.Context, key []byte) ([]byte, error) {
+				return stored[string(key)], nil
+			},
+			setExFunc: func(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error {
+				stored[string(key)] = value
+				return nil
+			},
+			ttlFunc: func(ctx context.Context, key []byte) (time.Duration, error) {
+				return 0, nil
+			},
+		}
+
+		deduper := NewDeduper(hashHandler, mockStorage, log, func() hash.Hash { return sha1.New() }, matchHandler, serializer)
+		assert.NotNil(t, deduper)
+
+		entity := TestEntity{ID: "123", Name: "Test"}
+		claimed, err := deduper.Claim(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, claimed)
+
+		claimed, err = deduper.Claim(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, claimed)
+	})
+
+	t.Run("Claim with error from storage", func(t *testing.T) {
+		mockStorage := &MockStorage{
+			existsFunc: func(ctx context.Context, key []byte) (bool, error) {
+				return false, nil
+			},
+			getFunc: func(ctx context.Context, key []byte) ([]byte, error) {
+				return nil, nil
+			},
+			setExFunc: func(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error {
+				return assert.AnError
+			},
+			ttlFunc: func(ctx context.Context, key []byte) (time.Duration, error) {
+				return 0, nil
+			},
+		}
+
+		deduper := NewDeduper(hashHandler, mockStorage, log, func() hash.Hash { return sha1.New() }, matchHandler, serializer)
+		assert.NotNil(t, deduper)
+
+		entity := TestEntity{ID: "123", Name: "Test"}
+		claimed, err := deduper.Claim(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.Error(t, err)
+		assert.False(t, claimed)
+		assert.Contains(t, err.Error(), "storage error")
+	})
+
 	t.Run("Store with error from storage", func(t *testing.T) {
 		storageError := assert.AnError
 		mockStorage := &MockStorage{
@@ -513,6 +734,41 @@ func TestDeduperComprehensive(t *testing.T) {
 		assert.Contains(t, err.Error(), "failed to store")
 	})
 
+	t.Run("ForgetHash with different Del responses", func(t *testing.T) {
+		mockStorage := &MockStorage{
+			delFunc: func(ctx context.Context, key []byte) (bool, error) {
+				return false, assert.AnError
+			},
+		}
+
+		deduper := NewDeduper(hashHandler, mockStorage, log, func() hash.Hash { return sha1.New() }, matchHandler, serializer)
+		assert.NotNil(t, deduper)
+
+		// Test storage error
+		err := deduper.ForgetHash(ctx, []byte("hash"))
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "storage error")
+
+		// Test key does not exist
+		mockStorage.delFunc = func(ctx context.Context, key []byte) (bool, error) {
+			return false, nil
+		}
+		err = deduper.ForgetHash(ctx, []byte("hash"))
+		assert.Error(t, err)
+		_, ok := errors.Has(err, DedupMissingKeyErrorCode)
+		assert.True(t, ok)
+
+		// Test key removed, along with its result
+		var deleted []string
+		mockStorage.delFunc = func(ctx context.Context, key []byte) (bool, error) {
+			deleted = append(deleted, string(key))
+			return true, nil
+		}
+		err = deduper.ForgetHash(ctx, []byte("hash"))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"dedup:dedup.TestEntity:hash", "dedup:dedup.TestEntity:\x00r:hash"}, deleted)
+	})
+
 	t.Run("TTL with different TTL responses", func(t *testing.T) {
 		mockStorage := &MockStorage{
 			existsFunc: func(ctx context.Context, key []byte) (bool, error) {
@@ -734,6 +990,145 @@ func TestDeduperWithRedis(t *testing.T) {
 		assert.False(t, isDuplicate)
 	})
 
+	t.Run("Claim is first writer wins under concurrency", func(t *testing.T) {
+		// Clean up before test
+		mr.FlushAll()
+
+		deduper := NewDeduper(hashHandler, storage, logger, func() hash.Hash { return sha1.New() }, matchHandler, serializer)
+		assert.NotNil(t, deduper)
+
+		entity := TestEntity{ID: "123", Name: "Test"}
+
+		var wg sync.WaitGroup
+		var won atomic.Int32
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				claimed, err := deduper.Claim(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+				assert.NoError(t, err)
+				if claimed {
+					won.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), won.Load(), "exactly one caller should win the claim")
+
+		// The claim stores the same value as Store, so value checks keep working
+		isDuplicate, err := deduper.IsValueDuplicate(ctx, entity, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+	})
+
+	t.Run("IsDuplicate with storeIfNot uses the atomic claim", func(t *testing.T) {
+		// Clean up before test
+		mr.FlushAll()
+
+		deduper := NewDeduper(hashHandler, storage, logger, func() hash.Hash { return sha1.New() }, matchHandler, serializer)
+		assert.NotNil(t, deduper)
+
+		entity := TestEntity{ID: "123", Name: "Test"}
+
+		var wg sync.WaitGroup
+		var fresh atomic.Int32
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				isDuplicate, err := deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+				assert.NoError(t, err)
+				if !isDuplicate {
+					fresh.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), fresh.Load(), "exactly one caller should see a new entity")
+	})
+
+	t.Run("IsValueDuplicate compares and swaps atomically", func(t *testing.T) {
+		// Clean up before test
+		mr.FlushAll()
+
+		// Keyed by ID only so the value can change under the same key;
+		// no matcher, so the value comparison runs in the Lua script
+		idHandler := func(ctx context.Context, entity TestEntity) ([]byte, error) {
+			return []byte(entity.ID), nil
+		}
+		deduper := NewDeduper(idHandler, storage, logger, func() hash.Hash { return sha1.New() }, nil, serializer)
+		assert.NotNil(t, deduper)
+
+		entity := TestEntity{ID: "123", Name: "Test"}
+
+		var wg sync.WaitGroup
+		var fresh atomic.Int32
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				isDuplicate, err := deduper.IsValueDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+				assert.NoError(t, err)
+				if !isDuplicate {
+					fresh.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), fresh.Load(), "exactly one caller should see a new value")
+
+		// Same key, different value: replaced when storeIfNot is given
+		changed := TestEntity{ID: "123", Name: "Changed"}
+		isDuplicate, err := deduper.IsValueDuplicate(ctx, changed, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		isDuplicate, err = deduper.IsValueDuplicate(ctx, entity, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate, "without storeIfNot the stored value is kept")
+
+		isDuplicate, err = deduper.IsValueDuplicate(ctx, changed, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		isDuplicate, err = deduper.IsValueDuplicate(ctx, changed, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+	})
+
+	t.Run("Forget releases a stored entity", func(t *testing.T) {
+		// Clean up before test
+		mr.FlushAll()
+
+		deduper := NewDeduper(hashHandler, storage, logger, func() hash.Hash { return sha1.New() }, matchHandler, serializer)
+		assert.NotNil(t, deduper)
+
+		entity := TestEntity{ID: "123", Name: "Test"}
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		// Downstream processing failed, roll the mark back
+		err = deduper.Forget(ctx, entity, DefaultHashStrategy())
+		assert.NoError(t, err)
+
+		// The retry is not dropped
+		isDuplicate, err = deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		// Forgetting twice reports the missing key
+		err = deduper.Forget(ctx, entity, DefaultHashStrategy())
+		assert.NoError(t, err)
+		err = deduper.Forget(ctx, entity, DefaultHashStrategy())
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "does not exist")
+	})
+
 	t.Run("Multiple entities with same properties", func(t *testing.T) {
 		// Clean up before test
 		mr.FlushAll()
@@ -898,3 +1293,106 @@ func TestDeduperWithRedis(t *testing.T) {
 		assert.True(t, isDuplicate, "Campaign should now be a duplicate")
 	})
 }
+
+// TestSlidingWindow tests TTL refresh on duplicate hits
+func TestSlidingWindow(t *testing.T) {
+	// Setup miniredis
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	storage := NewRedisStorage(ctx, client)
+
+	hashHandler := func(ctx context.Context, entity TestEntity) ([]byte, error) {
+		return []byte(entity.ID + ":" + entity.Name), nil
+	}
+
+	entity := TestEntity{ID: "123", Name: "Test"}
+	key := "dedup:dedup.TestEntity:123:Test"
+
+	t.Run("Fixed window is the default", func(t *testing.T) {
+		mr.FlushAll()
+
+		deduper := New(hashHandler, storage)
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		mr.FastForward(6 * time.Second)
+
+		isDuplicate, err = deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+		assert.Equal(t, 4*time.Second, mr.TTL(key))
+	})
+
+	t.Run("Duplicate hits extend the expiry", func(t *testing.T) {
+		mr.FlushAll()
+
+		deduper := New(hashHandler, storage, WithSlidingWindow())
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		// Keep sending duplicates past the original window
+		for i := 0; i < 3; i++ {
+			mr.FastForward(6 * time.Second)
+
+			isDuplicate, err = deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+			assert.NoError(t, err)
+			assert.True(t, isDuplicate)
+			assert.Equal(t, 10*time.Second, mr.TTL(key))
+		}
+
+		// Once duplicates stop the key expires
+		mr.FastForward(11 * time.Second)
+		isDuplicate, err = deduper.IsDuplicate(ctx, entity, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+
+	t.Run("Check without storeIfNot refreshes to the default TTL", func(t *testing.T) {
+		mr.FlushAll()
+
+		deduper := New(hashHandler, storage, WithSlidingWindow(), WithDefaultTTL(time.Minute))
+
+		_, _, err := deduper.Store(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, entity, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+		assert.Equal(t, time.Minute, mr.TTL(key))
+	})
+
+	t.Run("Fallback without ExpireStorage", func(t *testing.T) {
+		memory := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		mockStorage := &MockStorage{
+			existsFunc: memory.Exists,
+			getFunc:    memory.Get,
+			setExFunc:  memory.SetEX,
+			ttlFunc:    memory.TTL,
+		}
+
+		deduper := New(hashHandler, mockStorage, WithSlidingWindow())
+
+		_, _, err := deduper.Store(ctx, entity, DefaultHashStrategy(), 2*time.Second)
+		assert.NoError(t, err)
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		ttl, err := memory.TTL(ctx, []byte(key))
+		assert.NoError(t, err)
+		assert.True(t, ttl > 9*time.Second && ttl <= 10*time.Second)
+	})
+}
@@ -10,4 +10,10 @@ var (
 	DedupMissingKeyErrorCode = errors.NewErrorCode("DedupMissingKeyErrorCode", DedupErrorCodeNumber+errors.HTTPServerError)
 	DedupNoExpeirationKeyErrorCode = errors.NewErrorCode("DedupNoExpeirationKeyErrorCode", DedupErrorCodeNumber+errors.HTTPServerError)
 	DedupEntityTypeMismatchErrorCode = errors.NewErrorCode("DedupEntityTypeMismatchErrorCode", DedupErrorCodeNumber+errors.HTTPBadRequest)
+	DedupSerializerErrorCode = errors.NewErrorCode("DedupSerializerErrorCode", DedupErrorCodeNumber+errors.HTTPServerError)
+	DedupInvalidCounterErrorCode = errors.NewErrorCode("DedupInvalidCounterErrorCode", DedupErrorCodeNumber+errors.HTTPServerError)
+	DedupInvalidKeyFieldErrorCode = errors.NewErrorCode("DedupInvalidKeyFieldErrorCode", DedupErrorCodeNumber+errors.HTTPServerError)
+	DedupInvalidScopeErrorCode = errors.NewErrorCode("DedupInvalidScopeErrorCode", DedupErrorCodeNumber+errors.HTTPBadRequest)
+	DedupUnsupportedStorageErrorCode = errors.NewErrorCode("DedupUnsupportedStorageErrorCode", DedupErrorCodeNumber+errors.HTTPServerError)
+	DedupEntityTooLargeErrorCode = errors.NewErrorCode("DedupEntityTooLargeErrorCode", DedupErrorCodeNumber+413)
 )
\ No newline at end of file
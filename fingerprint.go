@@ -0,0 +1,107 @@
+package dedup
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"strings"
+)
+
+// Shingles splits text into lowercase word k-grams, the usual features of SimHash and MinHash.
+// Text shorter than k words is a single shingle.
+func Shingles(text string, k int) [][]byte {
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) == 0 {
+		return nil
+	}
+	if k <= 0 || len(words) <= k {
+		return [][]byte{[]byte(strings.Join(words, " "))}
+	}
+
+	shingles := make([][]byte, 0, len(words)-k+1)
+	for i := 0; i+k <= len(words); i++ {
+		shingles = append(shingles, []byte(strings.Join(words[i:i+k], " ")))
+	}
+	return shingles
+}
+
+// SimHash computes the 64-bit SimHash of features; similar feature sets have signatures
+// a small Hamming distance apart. Repeated features weigh more.
+func SimHash(features [][]byte) uint64 {
+	var weights [64]int
+	for _, feature := range features {
+		h := mix64(hash64(feature))
+		for i := range weights {
+			if h&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	var signature uint64
+	for i, w := range weights {
+		if w > 0 {
+			signature |= 1 << i
+		}
+	}
+	return signature
+}
+
+// HammingDistance is the number of bits that differ between two SimHash signatures
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// MinHash computes an n-value MinHash signature of features; the share of equal values
+// between two signatures estimates the Jaccard similarity of their feature sets.
+func MinHash(features [][]byte, n int) []uint64 {
+	signature := make([]uint64, n)
+	for i := range signature {
+		signature[i] = math.MaxUint64
+	}
+
+	for _, feature := range features {
+		// n hash functions from two, as h1 + i*h2 (Kirsch-Mitzenmacher), mixed for dispersion
+		h1 := hash64(feature)
+		h2 := mix64(h1) | 1
+		for i := range signature {
+			if h := mix64(h1 + uint64(i)*h2); h < signature[i] {
+				signature[i] = h
+			}
+		}
+	}
+	return signature
+}
+
+// Jaccard estimates the Jaccard similarity of two MinHash signatures of the same size
+func Jaccard(a, b []uint64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+
+	equal := 0
+	for i := range a {
+		if a[i] == b[i] {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(a))
+}
+
+func hash64(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// mix64 is the splitmix64 finalizer
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
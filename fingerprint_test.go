@@ -0,0 +1,41 @@
+package dedup
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	article = "The city council approved the new budget on Tuesday evening after a long debate about funding for public transport, " +
+		"road maintenance and the renovation of the central library. The mayor said the plan balances the needs of residents " +
+		"with the limits of the tax base and promised regular updates on spending throughout the year."
+	unrelated = "Quarterly earnings at the chip maker beat analyst expectations as demand for data center hardware kept growing, " +
+		"while the consumer segment shrank for the third quarter in a row and guidance for next year stayed cautious."
+)
+
+func TestShingles(t *testing.T) {
+	assert.Equal(t, [][]byte{[]byte("a b c"), []byte("b c d")}, Shingles("A  b\nc D", 3))
+	assert.Equal(t, [][]byte{[]byte("a b")}, Shingles("a b", 3))
+	assert.Nil(t, Shingles("  ", 3))
+}
+
+func TestSimHash(t *testing.T) {
+	edited := strings.Replace(article, "long debate", "heated debate", 1)
+
+	assert.Equal(t, 0, HammingDistance(SimHash(Shingles(article, 1)), SimHash(Shingles(article, 1))))
+	assert.LessOrEqual(t, HammingDistance(SimHash(Shingles(article, 1)), SimHash(Shingles(edited, 1))), 3)
+	assert.Greater(t, HammingDistance(SimHash(Shingles(article, 1)), SimHash(Shingles(unrelated, 1))), 10)
+}
+
+func TestMinHash(t *testing.T) {
+	edited := strings.Replace(article, "Tuesday", "Wednesday", 1)
+
+	signature := MinHash(Shingles(article, 3), 64)
+	assert.Len(t, signature, 64)
+	assert.Equal(t, 1.0, Jaccard(signature, MinHash(Shingles(article, 3), 64)))
+	assert.InDelta(t, 0.9, Jaccard(signature, MinHash(Shingles(edited, 3), 64)), 0.1)
+	assert.Less(t, Jaccard(signature, MinHash(Shingles(unrelated, 3), 64)), 0.1)
+	assert.Equal(t, 0.0, Jaccard(signature, signature[:32]))
+}
@@ -0,0 +1,311 @@
+// Package grpcdedup provides gRPC server interceptors for idempotent RPCs built on dedup.Deduper
+package grpcdedup
+
+import (
+	"context"
+	"time"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/pixie-sh/errors-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// MetadataKey is the incoming metadata key read by default
+const MetadataKey = "idempotency-key"
+
+// Request is the entity deduplicated by the interceptors
+type Request struct {
+	// FullMethod is the RPC method, so equal keys on different methods do not collide
+	FullMethod string
+	// Key is the idempotency key read from metadata, empty when deriving from Message
+	Key string
+	// Message is a copy of the request message taken before the handler runs, used when Key is empty
+	Message any
+}
+
+// RequestKey is the default key handler: the method plus the metadata key,
+// or the method plus the deterministic proto encoding of the request
+func RequestKey(_ context.Context, req Request) ([]byte, error) {
+	key := []byte(req.FullMethod + "\n")
+	if req.Key != "" {
+		return append(key, req.Key...), nil
+	}
+
+	msg, ok := req.Message.(proto.Message)
+	if !ok || msg == nil {
+		return nil, errors.New("request is not a proto message", dedup.DedupEntityNilErrorCode)
+	}
+
+	return proto.MarshalOptions{Deterministic: true}.MarshalAppend(key, msg)
+}
+
+// NewDeduper creates a Deduper keyed by RequestKey, as expected by the interceptors
+func NewDeduper(storage dedup.Storage, opts ...dedup.Option) *dedup.Deduper {
+	return dedup.New(RequestKey, storage, append([]dedup.Option{dedup.WithPrefix("dedup:grpc:")}, opts...)...)
+}
+
+type config struct {
+	metadataKey   string
+	fromRequest   bool
+	ttl           time.Duration
+	lease         time.Duration
+	strategy      dedup.HashStrategy
+	duplicateCode codes.Code
+	replay        bool
+}
+
+// Option configures the interceptors
+type Option func(c *config)
+
+// WithMetadataKey sets the incoming metadata key holding the idempotency key
+func WithMetadataKey(key string) Option {
+	return func(c *config) {
+		c.metadataKey = key
+	}
+}
+
+// WithRequestKey derives the key from the marshalled request when the metadata has none
+func WithRequestKey() Option {
+	return func(c *config) {
+		c.fromRequest = true
+	}
+}
+
+// WithTTL sets how long a completed key is remembered, defaults to the Deduper default TTL
+func WithTTL(ttl time.Duration) Option {
+	return func(c *config) {
+		c.ttl = ttl
+	}
+}
+
+// WithLease sets how long an in-flight key is held before a crashed call can be retried, defaults to 30s
+func WithLease(lease time.Duration) Option {
+	return func(c *config) {
+		c.lease = lease
+	}
+}
+
+// WithStrategy sets the key hashing strategy, defaults to the Deduper default strategy
+func WithStrategy(strategy dedup.HashStrategy) Option {
+	return func(c *config) {
+		c.strategy = strategy
+	}
+}
+
+// WithDuplicateCode sets the status code returned to duplicates, defaults to codes.AlreadyExists
+func WithDuplicateCode(code codes.Code) Option {
+	return func(c *config) {
+		c.duplicateCode = code
+	}
+}
+
+// WithReplay stores unary responses and returns them to duplicates instead of an error
+func WithReplay() Option {
+	return func(c *config) {
+		c.replay = true
+	}
+}
+
+func newConfig(deduper *dedup.Deduper, opts []Option) *config {
+	cfg := &config{
+		metadataKey:   MetadataKey,
+		ttl:           deduper.DefaultTTL(),
+		lease:         30 * time.Second,
+		strategy:      deduper.DefaultStrategy(),
+		duplicateCode: codes.AlreadyExists,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// UnaryServerInterceptor deduplicates unary RPCs.
+// The deduper must accept Request entities, see NewDeduper. Calls without a key pass through
+// unless WithRequestKey is set; failed or panicking calls release the key so they can be retried.
+func UnaryServerInterceptor(deduper *dedup.Deduper, opts ...Option) grpc.UnaryServerInterceptor {
+	cfg := newConfig(deduper, opts)
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		entity, ok := cfg.request(ctx, info.FullMethod, req)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		started, record, err := deduper.Begin(ctx, entity, cfg.strategy, cfg.lease)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		if !started {
+			return cfg.duplicate(record)
+		}
+
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			// handler panicked or failed, release the key so the call can be retried
+			_ = deduper.Forget(context.WithoutCancel(ctx), entity, cfg.strategy)
+		}()
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+
+		var result []byte
+		if msg, ok := resp.(proto.Message); ok && cfg.replay {
+			packed, err := anypb.New(msg)
+			if err == nil {
+				result, _ = proto.Marshal(packed)
+			}
+		}
+
+		err = deduper.Complete(context.WithoutCancel(ctx), entity, cfg.strategy, cfg.ttl, result)
+		completed = err == nil
+		return resp, nil
+	}
+}
+
+// StreamServerInterceptor deduplicates streaming RPCs; responses are never replayed.
+// With WithRequestKey and no metadata key, the key is derived from the first received message.
+func StreamServerInterceptor(deduper *dedup.Deduper, opts ...Option) grpc.StreamServerInterceptor {
+	cfg := newConfig(deduper, opts)
+
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		stream := &stream{ServerStream: ss, deduper: deduper, cfg: cfg, method: info.FullMethod}
+
+		if key := cfg.metadata(ctx); key != "" {
+			err := stream.begin(Request{FullMethod: info.FullMethod, Key: key})
+			if err != nil {
+				return err
+			}
+		} else if !cfg.fromRequest {
+			return handler(srv, ss)
+		}
+
+		// err stays set when the handler panics, so the key is released for a retry
+		err := status.Error(codes.Internal, "handler panicked")
+		defer func() { stream.finish(err) }()
+
+		err = handler(srv, stream)
+		return err
+	}
+}
+
+// request builds the entity for a unary call, false when the call has no key
+func (c *config) request(ctx context.Context, method string, req any) (Request, bool) {
+	if key := c.metadata(ctx); key != "" {
+		return Request{FullMethod: method, Key: key}, true
+	}
+	if c.fromRequest {
+		return Request{FullMethod: method, Message: snapshot(req)}, true
+	}
+	return Request{}, false
+}
+
+// snapshot copies a proto message before the handler sees it, so Complete and Forget
+// hash the message Begin hashed even when the handler modifies the request
+func snapshot(m any) any {
+	msg, ok := m.(proto.Message)
+	if !ok || msg == nil {
+		return m
+	}
+	return proto.Clone(msg)
+}
+
+func (c *config) metadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(c.metadataKey)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// duplicate answers a call whose key is in progress or completed
+func (c *config) duplicate(record dedup.IdempotencyRecord) (any, error) {
+	if c.replay && record.Status == dedup.StatusCompleted && record.Result != nil {
+		var packed anypb.Any
+		if err := proto.Unmarshal(record.Result, &packed); err == nil {
+			if resp, err := packed.UnmarshalNew(); err == nil {
+				return resp, nil
+			}
+		}
+	}
+
+	if record.Status == dedup.StatusInProgress {
+		return nil, status.Error(c.duplicateCode, "request with this idempotency key is in progress")
+	}
+	return nil, status.Error(c.duplicateCode, "request with this idempotency key was already processed")
+}
+
+// toStatus maps dedup error codes to gRPC status codes
+func toStatus(err error) error {
+	if _, ok := errors.Has(err, dedup.DedupEntityNilErrorCode); ok {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, ok := errors.Has(err, dedup.DedupEntityTypeMismatchErrorCode); ok {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// stream claims the key before the handler runs, or on the first received message
+type stream struct {
+	grpc.ServerStream
+	deduper *dedup.Deduper
+	cfg     *config
+	method  string
+	entity  *Request
+	checked bool
+}
+
+func (s *stream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil || s.checked {
+		return err
+	}
+	return s.begin(Request{FullMethod: s.method, Message: snapshot(m)})
+}
+
+func (s *stream) begin(entity Request) error {
+	s.checked = true
+
+	started, record, err := s.deduper.Begin(s.Context(), entity, s.cfg.strategy, s.cfg.lease)
+	if err != nil {
+		return toStatus(err)
+	}
+	if !started {
+		_, err = s.cfg.duplicate(dedup.IdempotencyRecord{Status: record.Status})
+		return err
+	}
+
+	s.entity = &entity
+	return nil
+}
+
+// finish completes the key owned by this stream, or releases it when the handler failed
+func (s *stream) finish(err error) {
+	if s.entity == nil {
+		return
+	}
+
+	ctx := context.WithoutCancel(s.Context())
+	if err == nil {
+		err = s.deduper.Complete(ctx, *s.entity, s.cfg.strategy, s.cfg.ttl, nil)
+	}
+	if err != nil {
+		_ = s.deduper.Forget(ctx, *s.entity, s.cfg.strategy)
+	}
+}
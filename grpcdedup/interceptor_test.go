@@ -0,0 +1,307 @@
+package grpcdedup
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// healthServer counts calls, fails and modifies the request on demand
+type healthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+	calls  atomic.Int32
+	fail   atomic.Bool
+	mutate atomic.Bool
+}
+
+func (h *healthServer) Check(_ context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	n := h.calls.Add(1)
+	if h.mutate.Load() {
+		req.Service = "defaulted"
+	}
+	if h.fail.Load() {
+		return nil, status.Error(codes.Unavailable, "unavailable")
+	}
+
+	// the status depends on the call number so replays are observable
+	resp := grpc_health_v1.HealthCheckResponse_SERVING
+	if n > 1 {
+		resp = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: resp}, nil
+}
+
+func (h *healthServer) Watch(_ *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	h.calls.Add(1)
+	if h.fail.Load() {
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+	return stream.Send(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING})
+}
+
+func setup(t *testing.T, deduper *dedup.Deduper, opts ...Option) (grpc_health_v1.HealthClient, *healthServer) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(deduper, opts...)),
+		grpc.StreamInterceptor(StreamServerInterceptor(deduper, opts...)),
+	)
+	health := &healthServer{}
+	grpc_health_v1.RegisterHealthServer(server, health)
+
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return grpc_health_v1.NewHealthClient(conn), health
+}
+
+func withKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, MetadataKey, key)
+}
+
+// incomingKey is withKey on the server side
+func incomingKey(ctx context.Context, key string) context.Context {
+	return metadata.NewIncomingContext(ctx, metadata.Pairs(MetadataKey, key))
+}
+
+func watch(ctx context.Context, client grpc_health_v1.HealthClient, service string) error {
+	stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+	if err != nil {
+		return err
+	}
+	_, err = stream.Recv()
+	return err
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	ctx := context.Background()
+	req := &grpc_health_v1.HealthCheckRequest{Service: "orders"}
+
+	t.Run("Calls without key pass through", func(t *testing.T) {
+		client, health := setup(t, NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})))
+
+		for i := 0; i < 2; i++ {
+			_, err := client.Check(ctx, req)
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, int32(2), health.calls.Load())
+	})
+
+	t.Run("Duplicate metadata key returns the configured code", func(t *testing.T) {
+		client, health := setup(t,
+			NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})),
+			WithDuplicateCode(codes.FailedPrecondition),
+		)
+
+		_, err := client.Check(withKey(ctx, "key-1"), req)
+		assert.NoError(t, err)
+
+		_, err = client.Check(withKey(ctx, "key-1"), req)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+		// A different key is processed
+		_, err = client.Check(withKey(ctx, "key-2"), req)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), health.calls.Load())
+	})
+
+	t.Run("Duplicates replay the stored response", func(t *testing.T) {
+		client, health := setup(t,
+			NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})),
+			WithReplay(),
+		)
+
+		resp, err := client.Check(withKey(ctx, "key-1"), req)
+		assert.NoError(t, err)
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+
+		resp, err = client.Check(withKey(ctx, "key-1"), req)
+		assert.NoError(t, err)
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+		assert.Equal(t, int32(1), health.calls.Load())
+	})
+
+	t.Run("Key derived from the marshalled request", func(t *testing.T) {
+		client, health := setup(t,
+			NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})),
+			WithRequestKey(),
+		)
+
+		_, err := client.Check(ctx, req)
+		assert.NoError(t, err)
+
+		_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "orders"})
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+		_, err = client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "payments"})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), health.calls.Load())
+	})
+
+	t.Run("Handlers modifying the request complete and release the original key", func(t *testing.T) {
+		client, health := setup(t,
+			NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})),
+			WithRequestKey(),
+		)
+		health.mutate.Store(true)
+
+		health.fail.Store(true)
+		for i := 0; i < 2; i++ {
+			_, err := client.Check(ctx, req)
+			assert.Equal(t, codes.Unavailable, status.Code(err))
+		}
+
+		health.fail.Store(false)
+		_, err := client.Check(ctx, req)
+		assert.NoError(t, err)
+
+		_, err = client.Check(ctx, req)
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "already processed")
+		assert.Equal(t, int32(3), health.calls.Load())
+	})
+
+	t.Run("Failed calls release the key", func(t *testing.T) {
+		client, health := setup(t, NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})))
+		health.fail.Store(true)
+
+		for i := 0; i < 2; i++ {
+			_, err := client.Check(withKey(ctx, "key-1"), req)
+			assert.Equal(t, codes.Unavailable, status.Code(err))
+		}
+		assert.Equal(t, int32(2), health.calls.Load())
+	})
+
+	t.Run("Panics release the key", func(t *testing.T) {
+		deduper := NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+		interceptor := UnaryServerInterceptor(deduper)
+		info := &grpc.UnaryServerInfo{FullMethod: grpc_health_v1.Health_Check_FullMethodName}
+		panicking := func(context.Context, any) (any, error) { panic("boom") }
+
+		assert.Panics(t, func() {
+			_, _ = interceptor(incomingKey(ctx, "key-1"), req, info, panicking)
+		})
+
+		record, err := deduper.Status(ctx, Request{FullMethod: info.FullMethod, Key: "key-1"}, deduper.DefaultStrategy())
+		assert.NoError(t, err)
+		assert.Equal(t, dedup.StatusAbsent, record.Status)
+	})
+
+	t.Run("In-flight duplicates are rejected", func(t *testing.T) {
+		storage := dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})
+		deduper := NewDeduper(storage)
+		client, health := setup(t, deduper)
+
+		started, _, err := deduper.Begin(ctx, Request{FullMethod: grpc_health_v1.Health_Check_FullMethodName, Key: "key-1"}, deduper.DefaultStrategy(), time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, started)
+
+		_, err = client.Check(withKey(ctx, "key-1"), req)
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "in progress")
+		assert.Equal(t, int32(0), health.calls.Load())
+	})
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Duplicate metadata key", func(t *testing.T) {
+		client, health := setup(t, NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})))
+
+		assert.NoError(t, watch(withKey(ctx, "key-1"), client, "orders"))
+
+		err := watch(withKey(ctx, "key-1"), client, "orders")
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+		assert.Equal(t, int32(1), health.calls.Load())
+	})
+
+	t.Run("Key derived from the first message", func(t *testing.T) {
+		client, health := setup(t,
+			NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})),
+			WithRequestKey(),
+		)
+
+		assert.NoError(t, watch(ctx, client, "orders"))
+
+		err := watch(ctx, client, "orders")
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+		// The duplicate is rejected on receive, before the handler runs
+		assert.NoError(t, watch(ctx, client, "payments"))
+		assert.Equal(t, int32(2), health.calls.Load())
+	})
+
+	t.Run("Failed streams release the key", func(t *testing.T) {
+		client, health := setup(t, NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})))
+		health.fail.Store(true)
+
+		for i := 0; i < 2; i++ {
+			err := watch(withKey(ctx, "key-1"), client, "orders")
+			assert.Equal(t, codes.Unavailable, status.Code(err))
+		}
+		assert.Equal(t, int32(2), health.calls.Load())
+	})
+
+	t.Run("Panics release the key", func(t *testing.T) {
+		deduper := NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+		interceptor := StreamServerInterceptor(deduper)
+		info := &grpc.StreamServerInfo{FullMethod: grpc_health_v1.Health_Watch_FullMethodName}
+		panicking := func(any, grpc.ServerStream) error { panic("boom") }
+
+		assert.Panics(t, func() {
+			_ = interceptor(nil, &serverStream{ctx: incomingKey(ctx, "key-1")}, info, panicking)
+		})
+
+		record, err := deduper.Status(ctx, Request{FullMethod: info.FullMethod, Key: "key-1"}, deduper.DefaultStrategy())
+		assert.NoError(t, err)
+		assert.Equal(t, dedup.StatusAbsent, record.Status)
+	})
+}
+
+// serverStream is a grpc.ServerStream carrying ctx, for calling interceptors without a server
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestRequestKey(t *testing.T) {
+	ctx := context.Background()
+
+	key, err := RequestKey(ctx, Request{FullMethod: "/svc/Method", Key: "abc"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("/svc/Method\nabc"), key)
+
+	first, err := RequestKey(ctx, Request{FullMethod: "/svc/Method", Message: &grpc_health_v1.HealthCheckRequest{Service: "a"}})
+	assert.NoError(t, err)
+	second, err := RequestKey(ctx, Request{FullMethod: "/svc/Other", Message: &grpc_health_v1.HealthCheckRequest{Service: "a"}})
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	_, err = RequestKey(ctx, Request{FullMethod: "/svc/Method", Message: "not a proto"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,374 @@
+// Package httpdedup provides a net/http idempotency middleware built on dedup.Deduper
+package httpdedup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/pixie-sh/errors-go"
+)
+
+// IdempotencyKeyHeader is the header read by the default key extractor
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// ReplayedHeader is set on responses replayed from a previous request
+const ReplayedHeader = "Idempotent-Replayed"
+
+// KeyExtractor returns the idempotency key of a request, or "" when it has none
+type KeyExtractor func(r *http.Request) (string, error)
+
+// HeaderKey reads the key from the given request header and prefixes it with the method and path,
+// so the same key sent to two routes never shares a response
+func HeaderKey(name string) KeyExtractor {
+	return func(r *http.Request) (string, error) {
+		value := r.Header.Get(name)
+		if value == "" {
+			return "", nil
+		}
+		return r.Method + " " + r.URL.EscapedPath() + " " + value, nil
+	}
+}
+
+// MethodPathKey uses the request method and path, including the query string, as key
+func MethodPathKey() KeyExtractor {
+	return func(r *http.Request) (string, error) {
+		return r.Method + " " + r.URL.RequestURI(), nil
+	}
+}
+
+// DefaultMaxBodyBytes is the largest body BodyHashKey reads, and WithReplay records, unless given another limit
+const DefaultMaxBodyBytes = 1 << 20
+
+// BodyHashKey uses the method, path and a sha256 of the body as key; the body is restored for the handler.
+// Bodies over maxBytes, DefaultMaxBodyBytes when not given, are rejected with 413.
+func BodyHashKey(maxBytes ...int64) KeyExtractor {
+	limit := int64(DefaultMaxBodyBytes)
+	if len(maxBytes) > 0 {
+		limit = maxBytes[0]
+	}
+
+	return func(r *http.Request) (string, error) {
+		var body []byte
+		if r.Body != nil {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, limit+1))
+			if err != nil {
+				return "", errors.Wrap(err, "failed to read request body", dedup.DedupEntityNilErrorCode)
+			}
+			if int64(len(body)) > limit {
+				return "", errors.New("request body exceeds %d bytes", limit, dedup.DedupEntityTooLargeErrorCode)
+			}
+			_ = r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		sum := sha256.Sum256(body)
+		return r.Method + " " + r.URL.RequestURI() + " " + hex.EncodeToString(sum[:]), nil
+	}
+}
+
+// InFlightPolicy decides what happens to a duplicate that arrives while the original is still being handled
+type InFlightPolicy int
+
+const (
+	// RejectInFlight answers 409 Conflict right away
+	RejectInFlight InFlightPolicy = iota
+	// WaitInFlight polls until the original completes and then replays it, or answers 409 on timeout
+	WaitInFlight
+)
+
+// ErrorHandler writes the response for a request that failed before reaching the handler
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+type config struct {
+	extractor    KeyExtractor
+	required     bool
+	ttl          time.Duration
+	lease        time.Duration
+	strategy     dedup.HashStrategy
+	inFlight     InFlightPolicy
+	waitTimeout  time.Duration
+	pollInterval time.Duration
+	replay       bool
+	maxReplay    int64
+	errorHandler ErrorHandler
+}
+
+// Option configures the middleware
+type Option func(c *config)
+
+// WithKeyExtractor sets how the key is read, defaults to the Idempotency-Key header
+func WithKeyExtractor(extractor KeyExtractor) Option {
+	return func(c *config) {
+		c.extractor = extractor
+	}
+}
+
+// WithRequiredKey rejects requests without a key with 400 instead of passing them through
+func WithRequiredKey() Option {
+	return func(c *config) {
+		c.required = true
+	}
+}
+
+// WithTTL sets how long a completed key is remembered, defaults to the Deduper default TTL
+func WithTTL(ttl time.Duration) Option {
+	return func(c *config) {
+		c.ttl = ttl
+	}
+}
+
+// WithLease sets how long an in-flight key is held before a crashed request can be retried, defaults to 30s
+func WithLease(lease time.Duration) Option {
+	return func(c *config) {
+		c.lease = lease
+	}
+}
+
+// WithStrategy sets the key hashing strategy, defaults to the Deduper default strategy
+func WithStrategy(strategy dedup.HashStrategy) Option {
+	return func(c *config) {
+		c.strategy = strategy
+	}
+}
+
+// WithInFlightPolicy sets the policy for concurrent duplicates; timeout and poll only apply to WaitInFlight
+func WithInFlightPolicy(policy InFlightPolicy, timeout time.Duration, poll time.Duration) Option {
+	return func(c *config) {
+		c.inFlight = policy
+		c.waitTimeout = timeout
+		c.pollInterval = poll
+	}
+}
+
+// WithReplay captures status, headers and body of completed requests and replays them to duplicates.
+// Responses with a body over maxBodyBytes, DefaultMaxBodyBytes when not given, are not recorded;
+// their duplicates get 409 Conflict.
+func WithReplay(maxBodyBytes ...int64) Option {
+	return func(c *config) {
+		c.replay = true
+		c.maxReplay = DefaultMaxBodyBytes
+		if len(maxBodyBytes) > 0 {
+			c.maxReplay = maxBodyBytes[0]
+		}
+	}
+}
+
+// WithErrorHandler replaces the default error responses
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(c *config) {
+		c.errorHandler = handler
+	}
+}
+
+// NewDeduper creates a Deduper keyed by the extracted idempotency key, as expected by Middleware
+func NewDeduper(storage dedup.Storage, opts ...dedup.Option) *dedup.Deduper {
+	handler := func(_ context.Context, key string) ([]byte, error) {
+		return []byte(key), nil
+	}
+	return dedup.New(handler, storage, append([]dedup.Option{dedup.WithPrefix("dedup:http:")}, opts...)...)
+}
+
+// Middleware returns an idempotency middleware.
+// The deduper must accept string entities, see NewDeduper. The first request for a key
+// runs the handler; duplicates get 409 Conflict or, WithReplay, the original response.
+// Responses with a 5xx status release the key so the request can be retried.
+//
+// Keys are shared by every client. Scope them per authenticated principal by setting
+// dedup.ContextWithScope on the request context in an outer middleware, so the response
+// of one client is never replayed to another that sends the same key.
+func Middleware(deduper *dedup.Deduper, opts ...Option) func(http.Handler) http.Handler {
+	cfg := config{
+		extractor:    HeaderKey(IdempotencyKeyHeader),
+		ttl:          deduper.DefaultTTL(),
+		lease:        30 * time.Second,
+		strategy:     deduper.DefaultStrategy(),
+		waitTimeout:  10 * time.Second,
+		pollInterval: 50 * time.Millisecond,
+		errorHandler: DefaultErrorHandler,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, err := cfg.extractor(r)
+			if err != nil {
+				cfg.errorHandler(w, r, err)
+				return
+			}
+
+			if key == "" {
+				if cfg.required {
+					cfg.errorHandler(w, r, errors.New("missing idempotency key", dedup.DedupEntityNilErrorCode))
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			serve(w, r, next, deduper, &cfg, key)
+		})
+	}
+}
+
+func serve(w http.ResponseWriter, r *http.Request, next http.Handler, deduper *dedup.Deduper, cfg *config, key string) {
+	ctx := r.Context()
+	deadline := time.Now().Add(cfg.waitTimeout)
+
+	for {
+		started, record, err := deduper.Begin(ctx, key, cfg.strategy, cfg.lease)
+		if err != nil {
+			cfg.errorHandler(w, r, err)
+			return
+		}
+
+		if started {
+			handle(w, r, next, deduper, cfg, key)
+			return
+		}
+
+		switch record.Status {
+		case dedup.StatusCompleted:
+			duplicate(w, r, cfg, record)
+			return
+		case dedup.StatusInProgress:
+			if cfg.inFlight != WaitInFlight || time.Now().After(deadline) {
+				http.Error(w, "request with this idempotency key is in progress", http.StatusConflict)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(cfg.pollInterval):
+			}
+		}
+		// StatusAbsent: the key expired or was released in between, try to start again
+	}
+}
+
+// handle runs the handler for the request that owns the key and records its outcome
+func handle(w http.ResponseWriter, r *http.Request, next http.Handler, deduper *dedup.Deduper, cfg *config, key string) {
+	ctx := r.Context()
+	rec := &recorder{ResponseWriter: w, status: http.StatusOK, capture: cfg.replay, limit: cfg.maxReplay}
+
+	completed := false
+	defer func() {
+		if completed {
+			return
+		}
+		// handler panicked or failed, release the key so the request can be retried;
+		// the response is already written so a release error has nowhere to go
+		_ = deduper.Forget(context.WithoutCancel(ctx), key, cfg.strategy)
+	}()
+
+	next.ServeHTTP(rec, r)
+
+	if rec.status >= http.StatusInternalServerError {
+		return
+	}
+
+	var result []byte
+	if cfg.replay && !rec.overflow {
+		if !rec.wroteHeader {
+			// implicit 200, the headers set by the handler are sent once it returns
+			rec.header = w.Header().Clone()
+		}
+
+		var err error
+		result, err = json.Marshal(response{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()})
+		if err != nil {
+			return
+		}
+	}
+
+	err := deduper.Complete(context.WithoutCancel(ctx), key, cfg.strategy, cfg.ttl, result)
+	completed = err == nil
+}
+
+// duplicate answers a request whose key was already completed
+func duplicate(w http.ResponseWriter, r *http.Request, cfg *config, record dedup.IdempotencyRecord) {
+	if cfg.replay && record.Result != nil {
+		var resp response
+		if err := json.Unmarshal(record.Result, &resp); err == nil {
+			for name, values := range resp.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(ReplayedHeader, "true")
+			w.WriteHeader(resp.Status)
+			_, _ = w.Write(resp.Body)
+			return
+		}
+	}
+
+	http.Error(w, "request with this idempotency key was already processed", http.StatusConflict)
+}
+
+// DefaultErrorHandler maps dedup error codes to their HTTP status
+func DefaultErrorHandler(w http.ResponseWriter, _ *http.Request, err error) {
+	status := http.StatusInternalServerError
+	if e, ok := errors.As(err); ok && e.GetHTTPStatus() != 0 {
+		status = e.GetHTTPStatus()
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// response is the captured response kept as the idempotency result
+type response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// recorder passes the response through while capturing it for replay, up to limit body bytes
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	capture     bool
+	limit       int64
+	overflow    bool
+	wroteHeader bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	r.status = status
+	if r.capture {
+		r.header = r.ResponseWriter.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.capture && !r.overflow {
+		if int64(r.body.Len()+len(b)) > r.limit {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
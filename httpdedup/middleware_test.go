@@ -0,0 +1,349 @@
+package httpdedup
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingStorage fails every call
+type failingStorage struct{}
+
+func (failingStorage) Get(context.Context, []byte) ([]byte, error) { return nil, assert.AnError }
+func (failingStorage) TTL(context.Context, []byte) (time.Duration, error) {
+	return 0, assert.AnError
+}
+func (failingStorage) SetEX(context.Context, []byte, []byte, ...time.Duration) error {
+	return assert.AnError
+}
+func (failingStorage) Exists(context.Context, []byte) (bool, error) { return false, assert.AnError }
+func (failingStorage) Del(context.Context, []byte) (bool, error)    { return false, assert.AnError }
+
+func newRequest(method string, target string, key string, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	return req
+}
+
+func TestMiddleware(t *testing.T) {
+	ctx := context.Background()
+
+	var calls atomic.Int32
+	created := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("X-Order", "42")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, `{"id":42}`)
+	})
+
+	t.Run("Requests without key pass through", func(t *testing.T) {
+		calls.Store(0)
+		deduper := NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+		handler := Middleware(deduper)(created)
+
+		for i := 0; i < 2; i++ {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "", ""))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+		}
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("Required key is a bad request when missing", func(t *testing.T) {
+		calls.Store(0)
+		deduper := NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+		handler := Middleware(deduper, WithRequiredKey())(created)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "", ""))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, int32(0), calls.Load())
+	})
+
+	t.Run("Duplicates get 409 without replay", func(t *testing.T) {
+		calls.Store(0)
+		deduper := NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+		handler := Middleware(deduper)(created)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "key-1", ""))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "key-1", ""))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("Duplicates replay the original response", func(t *testing.T) {
+		calls.Store(0)
+		deduper := NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+		handler := Middleware(deduper, WithReplay())(created)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "key-1", ""))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Empty(t, rec.Header().Get(ReplayedHeader))
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "key-1", ""))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "42", rec.Header().Get("X-Order"))
+		assert.Equal(t, "true", rec.Header().Get(ReplayedHeader))
+		assert.Equal(t, `{"id":42}`, rec.Body.String())
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("Keys are scoped per route", func(t *testing.T) {
+		calls.Store(0)
+		deduper := NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+		handler := Middleware(deduper, WithReplay())(created)
+
+		for _, target := range []string{"/payments", "/refunds"} {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newRequest(http.MethodPost, target, "key-1", ""))
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Empty(t, rec.Header().Get(ReplayedHeader))
+		}
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("Keys are scoped per principal", func(t *testing.T) {
+		calls.Store(0)
+		deduper := NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+		principal := func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.WithContext(dedup.ContextWithScope(r.Context(), r.Header.Get("X-Client"))))
+			})
+		}
+		handler := principal(Middleware(deduper, WithReplay())(created))
+
+		for _, client := range []string{"acme", "globex"} {
+			req := newRequest(http.MethodPost, "/orders", "key-1", "")
+			req.Header.Set("X-Client", client)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			assert.Empty(t, rec.Header().Get(ReplayedHeader))
+		}
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("Headers of an implicit 200 are replayed", func(t *testing.T) {
+		headersOnly := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Order", "42")
+		})
+		deduper := NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+		handler := Middleware(deduper, WithReplay())(headersOnly)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "key-1", ""))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "key-1", ""))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "42", rec.Header().Get("X-Order"))
+		assert.Equal(t, "true", rec.Header().Get(ReplayedHeader))
+	})
+
+	t.Run("Bodies over the replay limit are not recorded", func(t *testing.T) {
+		calls.Store(0)
+		deduper := NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+		handler := Middleware(deduper, WithReplay(8))(created)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "key-1", ""))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, `{"id":42}`, rec.Body.String())
+
+		record, err := deduper.Status(ctx, "POST /orders key-1", deduper.DefaultStrategy())
+		assert.NoError(t, err)
+		assert.Equal(t, dedup.StatusCompleted, record.Status)
+		assert.Nil(t, record.Result)
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "key-1", ""))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("Server errors release the key", func(t *testing.T) {
+		var failures atomic.Int32
+		failing := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			failures.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+
+		deduper := NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+		handler := Middleware(deduper)(failing)
+
+		for i := 0; i < 2; i++ {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "key-1", ""))
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		}
+		assert.Equal(t, int32(2), failures.Load())
+	})
+
+	t.Run("Panics release the key", func(t *testing.T) {
+		panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})
+
+		storage := dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})
+		deduper := NewDeduper(storage)
+		handler := Middleware(deduper)(panicking)
+
+		assert.Panics(t, func() {
+			handler.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodPost, "/orders", "key-1", ""))
+		})
+
+		record, err := deduper.Status(ctx, "POST /orders key-1", deduper.DefaultStrategy())
+		assert.NoError(t, err)
+		assert.Equal(t, dedup.StatusAbsent, record.Status)
+	})
+
+	t.Run("In-flight duplicates", func(t *testing.T) {
+		release := make(chan struct{})
+		entered := make(chan struct{})
+		slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(entered)
+			<-release
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = io.WriteString(w, "done")
+		})
+
+		storage := dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})
+		rejecting := Middleware(NewDeduper(storage))(slow)
+		waiting := Middleware(NewDeduper(storage), WithReplay(), WithInFlightPolicy(WaitInFlight, 5*time.Second, 5*time.Millisecond))(slow)
+
+		first := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			waiting.ServeHTTP(first, newRequest(http.MethodPost, "/orders", "key-1", ""))
+			close(done)
+		}()
+		<-entered
+
+		// Rejected right away while the original is running
+		rec := httptest.NewRecorder()
+		rejecting.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "key-1", ""))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+
+		// Waits for the original and replays it
+		waited := httptest.NewRecorder()
+		waitDone := make(chan struct{})
+		go func() {
+			waiting.ServeHTTP(waited, newRequest(http.MethodPost, "/orders", "key-1", ""))
+			close(waitDone)
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		<-done
+		<-waitDone
+
+		assert.Equal(t, http.StatusAccepted, first.Code)
+		assert.Equal(t, http.StatusAccepted, waited.Code)
+		assert.Equal(t, "done", waited.Body.String())
+		assert.Equal(t, "true", waited.Header().Get(ReplayedHeader))
+	})
+
+	t.Run("Wait times out with 409", func(t *testing.T) {
+		storage := dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})
+		deduper := NewDeduper(storage)
+
+		started, _, err := deduper.Begin(ctx, "POST /orders key-1", deduper.DefaultStrategy(), time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, started)
+
+		handler := Middleware(deduper, WithInFlightPolicy(WaitInFlight, 20*time.Millisecond, 5*time.Millisecond))(created)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "key-1", ""))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("Storage errors map to 500", func(t *testing.T) {
+		calls.Store(0)
+		handler := Middleware(NewDeduper(failingStorage{}))(created)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "key-1", ""))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, int32(0), calls.Load())
+	})
+
+	t.Run("Key extractors", func(t *testing.T) {
+		key, err := HeaderKey(IdempotencyKeyHeader)(newRequest(http.MethodPost, "/orders/a%20b?x=1", "key-1", ""))
+		assert.NoError(t, err)
+		assert.Equal(t, "POST /orders/a%20b key-1", key)
+
+		key, err = HeaderKey(IdempotencyKeyHeader)(newRequest(http.MethodPost, "/orders", "", ""))
+		assert.NoError(t, err)
+		assert.Empty(t, key)
+
+		key, err = MethodPathKey()(newRequest(http.MethodPut, "/orders/1?x=1", "", ""))
+		assert.NoError(t, err)
+		assert.Equal(t, "PUT /orders/1?x=1", key)
+
+		req := newRequest(http.MethodPost, "/orders", "", `{"a":1}`)
+		key, err = BodyHashKey()(req)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(key, "POST /orders "))
+
+		// The body is still readable by the handler
+		body, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"a":1}`, string(body))
+
+		other, err := BodyHashKey()(newRequest(http.MethodPost, "/orders", "", `{"a":2}`))
+		assert.NoError(t, err)
+		assert.NotEqual(t, key, other)
+
+		_, err = BodyHashKey(7)(newRequest(http.MethodPost, "/orders", "", `{"a":1}`))
+		assert.NoError(t, err)
+		_, err = BodyHashKey(6)(newRequest(http.MethodPost, "/orders", "", `{"a":1}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("Body over the limit is rejected with 413", func(t *testing.T) {
+		calls.Store(0)
+		deduper := NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+		handler := Middleware(deduper, WithKeyExtractor(BodyHashKey(16)), WithTTL(time.Minute))(created)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "", strings.Repeat("a", 17)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+		assert.Equal(t, int32(0), calls.Load())
+	})
+
+	t.Run("Body hash deduplicates identical payloads", func(t *testing.T) {
+		calls.Store(0)
+		deduper := NewDeduper(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+		handler := Middleware(deduper, WithKeyExtractor(BodyHashKey()), WithTTL(time.Minute))(created)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "", `{"a":1}`))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "", `{"a":1}`))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest(http.MethodPost, "/orders", "", `{"a":2}`))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+}
@@ -0,0 +1,126 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+)
+
+// IdempotencyStatus is the lifecycle state of an idempotency key
+type IdempotencyStatus int
+
+const (
+	// StatusAbsent means nobody holds the key, or a crashed worker's lease expired; call Begin and process
+	StatusAbsent IdempotencyStatus = iota
+	// StatusInProgress means another caller holds the lease; wait and poll Status again
+	StatusInProgress
+	// StatusCompleted means the entity was processed; skip it, replaying Result when set
+	StatusCompleted
+)
+
+func (s IdempotencyStatus) String() string {
+	switch s {
+	case StatusAbsent:
+		return "absent"
+	case StatusInProgress:
+		return "in-progress"
+	case StatusCompleted:
+		return "completed"
+	default:
+		return "unknown"
+	}
+}
+
+// IdempotencyRecord is the state stored under an idempotency key
+type IdempotencyRecord struct {
+	Status IdempotencyStatus
+	Result []byte
+}
+
+var (
+	inProgressMarker = []byte("\x00dedup:in-progress")
+	completedMarker  = []byte("\x00dedup:completed:")
+)
+
+// Begin atomically marks the entity in-progress for the lease duration, first caller wins.
+// When started is false the returned record tells what the current owner is doing.
+// Release the lease with Forget if processing fails so a retry can start again.
+func (d *Deduper) Begin(ctx context.Context, entity any, strategy HashStrategy, lease time.Duration) (bool, IdempotencyRecord, error) {
+	d.count(ctx, OpBegin, MetricCheck, 1)
+
+	dedupHash, err := d.Hash(ctx, entity, strategy, false)
+	if err != nil {
+		return false, IdempotencyRecord{}, err
+	}
+	key := d.buildKey(ctx, dedupHash)
+
+	started, err := SetNX(ctx, d.storage, key, inProgressMarker, lease)
+	if err != nil {
+		d.count(ctx, OpBegin, MetricStorageError, 1)
+		return false, IdempotencyRecord{}, errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
+	}
+	if started {
+		d.count(ctx, OpBegin, MetricStore, 1)
+		return true, IdempotencyRecord{Status: StatusInProgress}, nil
+	}
+
+	d.count(ctx, OpBegin, MetricDuplicate, 1)
+	record, err := d.status(ctx, key)
+	d.fail(ctx, OpBegin, err)
+	return false, record, err
+}
+
+// Complete marks the entity done for the expiration duration, keeping an optional result to replay
+func (d *Deduper) Complete(ctx context.Context, entity any, strategy HashStrategy, expiration time.Duration, result []byte) error {
+	dedupHash, err := d.Hash(ctx, entity, strategy, false)
+	if err != nil {
+		return err
+	}
+	key := d.buildKey(ctx, dedupHash)
+
+	value := make([]byte, 0, len(completedMarker)+len(result))
+	value = append(value, completedMarker...)
+	value = append(value, result...)
+
+	err = d.storage.SetEX(ctx, key, value, expiration)
+	if err != nil {
+		d.count(ctx, OpComplete, MetricStorageError, 1)
+		return errors.Wrap(err, "failed to store dedupHash; %s", err.Error(), DedupStorageErrorCode)
+	}
+	d.count(ctx, OpComplete, MetricStore, 1)
+	return nil
+}
+
+// Status reports whether a caller should wait (StatusInProgress), skip (StatusCompleted) or process (StatusAbsent)
+func (d *Deduper) Status(ctx context.Context, entity any, strategy HashStrategy) (IdempotencyRecord, error) {
+	dedupHash, err := d.Hash(ctx, entity, strategy, false)
+	if err != nil {
+		return IdempotencyRecord{}, err
+	}
+	return d.status(ctx, d.buildKey(ctx, dedupHash))
+}
+
+func (d *Deduper) status(ctx context.Context, key []byte) (IdempotencyRecord, error) {
+	existing, err := d.storage.Get(ctx, key)
+	if err != nil {
+		return IdempotencyRecord{}, errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
+	}
+
+	switch {
+	case IsEmpty(existing):
+		return IdempotencyRecord{Status: StatusAbsent}, nil
+	case bytes.Equal(existing, inProgressMarker):
+		return IdempotencyRecord{Status: StatusInProgress}, nil
+	case bytes.HasPrefix(existing, completedMarker):
+		result := existing[len(completedMarker):]
+		if len(result) == 0 {
+			result = nil
+		}
+		return IdempotencyRecord{Status: StatusCompleted, Result: result}, nil
+	default:
+		// keys written by Store or StoreHash count as completed without a result
+		return IdempotencyRecord{Status: StatusCompleted}, nil
+	}
+}
@@ -0,0 +1,148 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyLifecycle(t *testing.T) {
+	ctx := context.Background()
+
+	hashHandler := func(ctx context.Context, key string) ([]byte, error) {
+		return []byte(key), nil
+	}
+
+	t.Run("Begin, concurrent Begin and Complete", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+		deduper := New(hashHandler, storage)
+
+		record, err := deduper.Status(ctx, "order-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.Equal(t, StatusAbsent, record.Status)
+
+		started, _, err := deduper.Begin(ctx, "order-1", DefaultHashStrategy(), 30*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, started)
+
+		// A concurrent caller must wait
+		started, record, err = deduper.Begin(ctx, "order-1", DefaultHashStrategy(), 30*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, started)
+		assert.Equal(t, StatusInProgress, record.Status)
+
+		err = deduper.Complete(ctx, "order-1", DefaultHashStrategy(), time.Hour, []byte(`{"id":1}`))
+		assert.NoError(t, err)
+
+		// Later callers skip and replay the result
+		started, record, err = deduper.Begin(ctx, "order-1", DefaultHashStrategy(), 30*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, started)
+		assert.Equal(t, StatusCompleted, record.Status)
+		assert.Equal(t, []byte(`{"id":1}`), record.Result)
+
+		// The completed key outlives the lease
+		clock.Advance(time.Minute)
+		record, err = deduper.Status(ctx, "order-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.Equal(t, StatusCompleted, record.Status)
+
+		ttl, err := deduper.TTL(ctx, []byte("order-1"))
+		assert.NoError(t, err)
+		assert.Equal(t, 59*time.Minute, ttl)
+	})
+
+	t.Run("Crashed worker lease expires", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+		deduper := New(hashHandler, storage)
+
+		started, _, err := deduper.Begin(ctx, "order-1", DefaultHashStrategy(), 30*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, started)
+
+		clock.Advance(31 * time.Second)
+
+		record, err := deduper.Status(ctx, "order-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.Equal(t, StatusAbsent, record.Status)
+
+		started, _, err = deduper.Begin(ctx, "order-1", DefaultHashStrategy(), 30*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, started)
+	})
+
+	t.Run("Forget releases a failed lease", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		deduper := New(hashHandler, storage)
+
+		started, _, err := deduper.Begin(ctx, "order-1", DefaultHashStrategy(), 30*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, started)
+
+		err = deduper.Forget(ctx, "order-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+
+		started, _, err = deduper.Begin(ctx, "order-1", DefaultHashStrategy(), 30*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, started)
+	})
+
+	t.Run("Complete without result and keys written by Store", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		deduper := New(hashHandler, storage)
+
+		err := deduper.Complete(ctx, "order-1", DefaultHashStrategy(), time.Hour, nil)
+		assert.NoError(t, err)
+
+		record, err := deduper.Status(ctx, "order-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.Equal(t, StatusCompleted, record.Status)
+		assert.Nil(t, record.Result)
+
+		_, _, err = deduper.Store(ctx, "order-2", DefaultHashStrategy(), time.Hour)
+		assert.NoError(t, err)
+
+		record, err = deduper.Status(ctx, "order-2", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.Equal(t, StatusCompleted, record.Status)
+		assert.Nil(t, record.Result)
+
+		// In-progress keys are duplicates for IsDuplicate too
+		_, _, err = deduper.Begin(ctx, "order-3", DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		isDuplicate, err := deduper.IsDuplicate(ctx, "order-3", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+	})
+
+	t.Run("Storage errors", func(t *testing.T) {
+		mockStorage := &MockStorage{
+			existsFunc: func(ctx context.Context, key []byte) (bool, error) {
+				return false, assert.AnError
+			},
+			getFunc: func(ctx context.Context, key []byte) ([]byte, error) {
+				return nil, assert.AnError
+			},
+			setExFunc: func(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error {
+				return assert.AnError
+			},
+		}
+		deduper := New(hashHandler, mockStorage)
+
+		_, _, err := deduper.Begin(ctx, "order-1", DefaultHashStrategy(), time.Minute)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "storage error")
+
+		err = deduper.Complete(ctx, "order-1", DefaultHashStrategy(), time.Minute, nil)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to store")
+
+		_, err = deduper.Status(ctx, "order-1", DefaultHashStrategy())
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "storage error")
+	})
+}
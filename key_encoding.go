@@ -0,0 +1,138 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/pixie-sh/errors-go"
+)
+
+// KeyEncoding selects how Hash lays out key hashes, see WithKeyEncoding
+type KeyEncoding int
+
+const (
+	// LegacyKeys uses the raw handler bytes or the raw digest untagged, so under AutoSmart
+	// a short raw input equal to some digest shares its key. Binary handler output starting
+	// with a NUL byte may also share its key with a result, counter or fingerprint key.
+	LegacyKeys KeyEncoding = iota
+	// TaggedKeys prefixes raw inputs with "r:" and digests with "h:", digests kept binary
+	TaggedKeys
+	// HexKeys is TaggedKeys with hex digests, readable in redis-cli
+	HexKeys
+	// Base64Keys is TaggedKeys with unpadded URL-safe base64 digests
+	Base64Keys
+)
+
+// Key hash tags of the tagged encodings
+var (
+	rawTag    = []byte("r:")
+	digestTag = []byte("h:")
+)
+
+// encodeKey tags the key hash with its mode and encodes digests
+func encodeKey(encoding KeyEncoding, input []byte, hashed bool) []byte {
+	if encoding == LegacyKeys {
+		return input
+	}
+	if !hashed {
+		return append(append(make([]byte, 0, len(rawTag)+len(input)), rawTag...), input...)
+	}
+
+	switch encoding {
+	case HexKeys:
+		return hex.AppendEncode(append([]byte(nil), digestTag...), input)
+	case Base64Keys:
+		return base64.RawURLEncoding.AppendEncode(append([]byte(nil), digestTag...), input)
+	default:
+		return append(append(make([]byte, 0, len(digestTag)+len(input)), digestTag...), input...)
+	}
+}
+
+// legacyHash returns the untagged hash a tagged one was derived from,
+// nil when the legacy fallback is off or hash is not tagged
+func (d *Deduper) legacyHash(hash []byte) []byte {
+	if !d.legacyKeys || d.keyEncoding == LegacyKeys {
+		return nil
+	}
+
+	if raw, ok := bytes.CutPrefix(hash, rawTag); ok {
+		return raw
+	}
+	digest, ok := bytes.CutPrefix(hash, digestTag)
+	if !ok {
+		return nil
+	}
+
+	var err error
+	switch d.keyEncoding {
+	case HexKeys:
+		digest, err = hex.AppendDecode(nil, digest)
+	case Base64Keys:
+		digest, err = base64.RawURLEncoding.AppendDecode(nil, digest)
+	}
+	if err != nil {
+		return nil
+	}
+	return digest
+}
+
+// legacyExists reports whether the legacy key of hash exists
+func (d *Deduper) legacyExists(ctx context.Context, hash []byte) (bool, error) {
+	legacy := d.legacyHash(hash)
+	if legacy == nil {
+		return false, nil
+	}
+
+	exists, err := d.storage.Exists(ctx, d.buildKey(ctx, legacy))
+	if err != nil {
+		return false, errors.Wrap(err, "storage error for legacy key; %s", err.Error(), DedupStorageErrorCode)
+	}
+	return exists, nil
+}
+
+// legacyExistsBatch sets the entries of exists whose hash is missing but whose legacy key exists,
+// checking every legacy key in one round trip
+func (d *Deduper) legacyExistsBatch(ctx context.Context, hashes [][]byte, exists []bool) error {
+	var keys [][]byte
+	var positions []int
+	for n, hash := range hashes {
+		if exists[n] {
+			continue
+		}
+		if legacy := d.legacyHash(hash); legacy != nil {
+			keys = append(keys, d.buildKey(ctx, legacy))
+			positions = append(positions, n)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	found, err := ExistsBatch(ctx, d.storage, keys)
+	if err != nil {
+		return errors.Wrap(err, "storage error for legacy keys; %s", err.Error(), DedupStorageErrorCode)
+	}
+	for i, n := range positions {
+		exists[n] = found[i]
+	}
+	return nil
+}
+
+// legacyValue returns the value stored under the legacy key of hash, nil when there is none
+func (d *Deduper) legacyValue(ctx context.Context, hash []byte) ([]byte, error) {
+	legacy := d.legacyHash(hash)
+	if legacy == nil {
+		return nil, nil
+	}
+
+	value, err := d.storage.Get(ctx, d.buildKey(ctx, legacy))
+	if err != nil {
+		return nil, errors.Wrap(err, "storage error for legacy key; %s", err.Error(), DedupStorageErrorCode)
+	}
+	if IsEmpty(value) {
+		return nil, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,116 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyEncoding(t *testing.T) {
+	ctx := context.Background()
+	handler := func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }
+	strategy := HashStrategy{KeyHashMode: AutoSmart, KeyThreshold: 32, ValThreshold: 256}
+
+	long := "a key long enough to be hashed under AutoSmart"
+	digest := sha256.Sum256([]byte(long))
+
+	t.Run("Raw input equal to a digest", func(t *testing.T) {
+		// legacy keys cannot tell a short raw input from a digest
+		legacy := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}))
+		_, err := legacy.IsDuplicate(ctx, long, strategy, time.Minute)
+		assert.NoError(t, err)
+		isDuplicate, err := legacy.IsDuplicate(ctx, string(digest[:]), strategy, time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		tagged := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}), WithKeyEncoding(TaggedKeys))
+		_, err = tagged.IsDuplicate(ctx, long, strategy, time.Minute)
+		assert.NoError(t, err)
+		isDuplicate, err = tagged.IsDuplicate(ctx, string(digest[:]), strategy, time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+
+	t.Run("Encodings", func(t *testing.T) {
+		cases := map[KeyEncoding]string{
+			LegacyKeys: string(digest[:]),
+			TaggedKeys: "h:" + string(digest[:]),
+			HexKeys:    "h:" + hex.EncodeToString(digest[:]),
+			Base64Keys: "h:" + base64.RawURLEncoding.EncodeToString(digest[:]),
+		}
+		for encoding, expected := range cases {
+			d := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}), WithKeyEncoding(encoding))
+
+			hash, err := d.Hash(ctx, long, strategy, false)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, string(hash))
+
+			raw, err := d.Hash(ctx, "short", strategy, false)
+			assert.NoError(t, err)
+			if encoding == LegacyKeys {
+				assert.Equal(t, "short", string(raw))
+			} else {
+				assert.Equal(t, "r:short", string(raw))
+			}
+
+			// values are never tagged
+			value, err := d.Hash(ctx, "short", strategy, true)
+			assert.NoError(t, err)
+			assert.Equal(t, "short", string(value))
+		}
+	})
+
+	t.Run("Legacy fallback", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		legacy := New(handler, storage, WithPrefix("orders:"))
+		_, err := legacy.IsDuplicate(ctx, long, strategy, time.Minute)
+		assert.NoError(t, err)
+		_, err = legacy.IsDuplicate(ctx, "short", strategy, time.Minute)
+		assert.NoError(t, err)
+
+		// without the fallback the migration hides existing entries
+		migrated := New(handler, storage, WithPrefix("orders:"), WithKeyEncoding(HexKeys))
+		isDuplicate, err := migrated.IsDuplicate(ctx, long, strategy)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		migrated = New(handler, storage, WithPrefix("orders:"), WithKeyEncoding(HexKeys), WithLegacyKeyFallback())
+		for _, entity := range []string{long, "short"} {
+			isDuplicate, err = migrated.IsDuplicate(ctx, entity, strategy)
+			assert.NoError(t, err)
+			assert.True(t, isDuplicate, entity)
+
+			claimed, err := migrated.Claim(ctx, entity, strategy, time.Minute)
+			assert.NoError(t, err)
+			assert.False(t, claimed, entity)
+
+			results, errs := migrated.IsDuplicateBatch(ctx, []any{entity, "unseen " + entity}, strategy, time.Minute)
+			assert.Equal(t, []error{nil, nil}, errs)
+			assert.Equal(t, []bool{true, false}, results, entity)
+
+			isDuplicate, err = migrated.IsValueDuplicate(ctx, entity, strategy)
+			assert.NoError(t, err)
+			assert.True(t, isDuplicate, entity)
+
+			hash, err := migrated.Hash(ctx, entity, strategy, false)
+			assert.NoError(t, err)
+			ttl, err := migrated.TTL(ctx, hash)
+			assert.NoError(t, err)
+			assert.Greater(t, ttl, time.Duration(0))
+		}
+
+		// Forget removes the legacy key too
+		assert.NoError(t, migrated.Forget(ctx, long, strategy))
+		isDuplicate, err = migrated.IsDuplicate(ctx, long, strategy)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+		isDuplicate, err = legacy.IsDuplicate(ctx, long, strategy)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+}
@@ -0,0 +1,382 @@
+package dedup
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorageConfig configures a MemoryStorage
+type MemoryStorageConfig struct {
+	// MaxSize caps the number of keys held, evicting the least recently used one first; 0 means unbounded
+	MaxSize int
+	// SweepInterval is how often expired keys are purged in the background;
+	// 0 uses a one minute interval and a negative value disables the sweeper
+	SweepInterval time.Duration
+	// Now returns the current time, defaults to time.Now; inject a fake clock for deterministic expiry
+	Now func() time.Time
+}
+
+type memoryEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time // zero means no expiration
+}
+
+// MemoryStorage implements the binary-safe Storage interface in process memory.
+// It is safe for concurrent use and mirrors RedisStorage expiry semantics.
+type MemoryStorage struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used entry
+	maxSize int
+	now     func() time.Time
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMemoryStorage creates a new MemoryStorage instance.
+// The background sweeper runs until ctx is done or Close is called.
+func NewMemoryStorage(ctx context.Context, config ...MemoryStorageConfig) *MemoryStorage {
+	var cfg MemoryStorageConfig
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if cfg.SweepInterval == 0 {
+		cfg.SweepInterval = time.Minute
+	}
+
+	m := &MemoryStorage{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		maxSize: cfg.MaxSize,
+		now:     cfg.Now,
+		stop:    make(chan struct{}),
+	}
+
+	if cfg.SweepInterval > 0 {
+		go m.sweeper(ctx, cfg.SweepInterval)
+	}
+
+	return m
+}
+
+// Close stops the background sweeper
+func (m *MemoryStorage) Close() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// Len returns the number of keys currently held, including expired keys not yet swept
+func (m *MemoryStorage) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.lru.Len()
+}
+
+// Get retrieves a binary-safe value by key
+func (m *MemoryStorage) Get(_ context.Context, key []byte) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.lookup(string(key))
+	if entry == nil {
+		return nil, nil
+	}
+	return clone(entry.value), nil
+}
+
+// Exists checks if the given binary key exists
+func (m *MemoryStorage) Exists(_ context.Context, key []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lookup(string(key)) != nil, nil
+}
+
+// Del removes the given binary key and reports whether it existed
+func (m *MemoryStorage) Del(_ context.Context, key []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lookup(string(key)) == nil {
+		return false, nil
+	}
+
+	m.remove(m.entries[string(key)])
+	return true, nil
+}
+
+// SetEX stores a binary-safe value with a key and expiration time
+func (m *MemoryStorage) SetEX(_ context.Context, key []byte, value []byte, expiration ...time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set(string(key), value, expirationOf(expiration))
+	return nil
+}
+
+// SetNX stores a binary-safe value only if the key does not exist yet
+func (m *MemoryStorage) SetNX(_ context.Context, key []byte, value []byte, expiration ...time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lookup(string(key)) != nil {
+		return false, nil
+	}
+
+	m.set(string(key), value, expirationOf(expiration))
+	return true, nil
+}
+
+// CompareAndSwap compares the stored value with value and replaces it on mismatch when replace is set
+func (m *MemoryStorage) CompareAndSwap(_ context.Context, key []byte, value []byte, replace bool, expiration ...time.Duration) (bool, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.lookup(string(key))
+	if entry != nil && bytes.Equal(entry.value, value) {
+		return true, false, nil
+	}
+	if !replace {
+		return false, false, nil
+	}
+
+	m.set(string(key), value, expirationOf(expiration))
+	return false, true, nil
+}
+
+// Incr increments the integer counter under key, creating it at 1 with the expiration
+func (m *MemoryStorage) Incr(_ context.Context, key []byte, expiration time.Duration) (int64, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.lookup(string(key))
+	if entry == nil {
+		m.set(string(key), []byte("1"), expiration)
+		return 1, remainingOf(expiration), nil
+	}
+
+	count, err := parseCounter(entry.value)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	count++
+	entry.value = []byte(strconv.FormatInt(count, 10))
+	if entry.expiresAt.IsZero() {
+		if expiration <= 0 {
+			return count, -1, nil
+		}
+		entry.expiresAt = m.now().Add(expiration)
+	}
+	return count, entry.expiresAt.Sub(m.now()), nil
+}
+
+// Expire refreshes the expiration of a binary key; a non-positive expiration removes it like Redis does
+func (m *MemoryStorage) Expire(_ context.Context, key []byte, expiration time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.lookup(string(key))
+	if entry == nil {
+		return false, nil
+	}
+
+	if expiration <= 0 {
+		m.remove(m.entries[string(key)])
+		return true, nil
+	}
+
+	entry.expiresAt = m.now().Add(expiration)
+	return true, nil
+}
+
+// TTL retrieves the remaining time-to-live for a given binary key.
+// Like Redis it returns -2 for missing keys and -1 for keys without expiration.
+func (m *MemoryStorage) TTL(_ context.Context, key []byte) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[string(key)]
+	if !ok {
+		return -2, nil
+	}
+
+	entry := elem.Value.(*memoryEntry)
+	if entry.expiresAt.IsZero() {
+		return -1, nil
+	}
+
+	ttl := entry.expiresAt.Sub(m.now())
+	if ttl <= 0 {
+		m.remove(elem)
+		return -2, nil
+	}
+	return ttl, nil
+}
+
+// ExistsBatch checks many binary keys under a single lock
+func (m *MemoryStorage) ExistsBatch(_ context.Context, keys [][]byte) ([]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	exists := make([]bool, len(keys))
+	for i, key := range keys {
+		exists[i] = m.lookup(string(key)) != nil
+	}
+	return exists, nil
+}
+
+// Scan calls fn with every live key starting with prefix, from a snapshot taken under the lock
+func (m *MemoryStorage) Scan(_ context.Context, prefix []byte, fn func(key []byte) error) error {
+	m.mu.Lock()
+	now := m.now()
+	var keys [][]byte
+	for key, elem := range m.entries {
+		if strings.HasPrefix(key, string(prefix)) && !m.expired(elem.Value.(*memoryEntry), now) {
+			keys = append(keys, []byte(key))
+		}
+	}
+	m.mu.Unlock()
+
+	for _, key := range keys {
+		err := fn(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge deletes every live key starting with prefix under a single lock
+func (m *MemoryStorage) Purge(_ context.Context, prefix []byte) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	var purged int64
+	for key, elem := range m.entries {
+		if !strings.HasPrefix(key, string(prefix)) {
+			continue
+		}
+		if !m.expired(elem.Value.(*memoryEntry), now) {
+			purged++
+		}
+		m.remove(elem)
+	}
+	return purged, nil
+}
+
+// SetEXBatch stores many binary-safe values under a single lock
+func (m *MemoryStorage) SetEXBatch(_ context.Context, keys [][]byte, values [][]byte, expiration ...time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	exp := expirationOf(expiration)
+	for i, key := range keys {
+		m.set(string(key), values[i], exp)
+	}
+	return nil
+}
+
+// lookup returns the live entry for key and marks it as recently used; must hold m.mu
+func (m *MemoryStorage) lookup(key string) *memoryEntry {
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil
+	}
+
+	entry := elem.Value.(*memoryEntry)
+	if m.expired(entry, m.now()) {
+		m.remove(elem)
+		return nil
+	}
+
+	m.lru.MoveToFront(elem)
+	return entry
+}
+
+// set writes key and evicts least recently used keys above maxSize; must hold m.mu
+func (m *MemoryStorage) set(key string, value []byte, expiration time.Duration) {
+	var expiresAt time.Time
+	if expiration > 0 {
+		expiresAt = m.now().Add(expiration)
+	}
+
+	if elem, ok := m.entries[key]; ok {
+		entry := elem.Value.(*memoryEntry)
+		entry.value = clone(value)
+		entry.expiresAt = expiresAt
+		m.lru.MoveToFront(elem)
+		return
+	}
+
+	m.entries[key] = m.lru.PushFront(&memoryEntry{key: key, value: clone(value), expiresAt: expiresAt})
+	for m.maxSize > 0 && m.lru.Len() > m.maxSize {
+		m.remove(m.lru.Back())
+	}
+}
+
+// remove drops an entry; must hold m.mu
+func (m *MemoryStorage) remove(elem *list.Element) {
+	m.lru.Remove(elem)
+	delete(m.entries, elem.Value.(*memoryEntry).key)
+}
+
+func (m *MemoryStorage) expired(entry *memoryEntry, now time.Time) bool {
+	return !entry.expiresAt.IsZero() && !now.Before(entry.expiresAt)
+}
+
+// Sweep purges every expired key; it is called periodically by the background sweeper
+func (m *MemoryStorage) Sweep() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for elem := m.lru.Front(); elem != nil; {
+		next := elem.Next()
+		if m.expired(elem.Value.(*memoryEntry), now) {
+			m.remove(elem)
+		}
+		elem = next
+	}
+}
+
+func (m *MemoryStorage) sweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.Sweep()
+		}
+	}
+}
+
+// expirationOf mirrors RedisStorage defaults: one hour when omitted, no expiration when not positive
+func expirationOf(expiration []time.Duration) time.Duration {
+	if len(expiration) > 0 {
+		return expiration[0]
+	}
+	return time.Hour
+}
+
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append(make([]byte, 0, len(b)), b...)
+}
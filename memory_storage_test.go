@@ -0,0 +1,401 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a manually advanced clock for deterministic expiry tests
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Exists and Get", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+
+		// Key doesn't exist initially
+		exists, err := storage.Exists(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		val, err := storage.Get(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.Nil(t, val)
+
+		// Set the key
+		err = storage.SetEX(ctx, []byte("test-key"), []byte("value"), 10*time.Second)
+		assert.NoError(t, err)
+
+		exists, err = storage.Exists(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+
+		val, err = storage.Get(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), val)
+
+		// Returned values are copies
+		val[0] = 'X'
+		val, err = storage.Get(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), val)
+	})
+
+	t.Run("SetEX expiry", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+
+		err := storage.SetEX(ctx, []byte("test-key"), []byte("value"), 1*time.Second)
+		assert.NoError(t, err)
+
+		exists, err := storage.Exists(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+
+		clock.Advance(2 * time.Second)
+
+		exists, err = storage.Exists(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+		assert.Equal(t, 0, storage.Len())
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+
+		err := storage.SetEX(ctx, []byte("test-key"), []byte("value"), 10*time.Second)
+		assert.NoError(t, err)
+
+		ttl, err := storage.TTL(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.Equal(t, 10*time.Second, ttl)
+
+		clock.Advance(4 * time.Second)
+
+		ttl, err = storage.TTL(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.Equal(t, 6*time.Second, ttl)
+
+		// Key with no expiration
+		err = storage.SetEX(ctx, []byte("persistent-key"), []byte("value"), 0)
+		assert.NoError(t, err)
+
+		ttl, err = storage.TTL(ctx, []byte("persistent-key"))
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(-1), ttl)
+
+		// Non-existent and expired keys
+		ttl, err = storage.TTL(ctx, []byte("non-existent-key"))
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(-2), ttl)
+
+		clock.Advance(6 * time.Second)
+		ttl, err = storage.TTL(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.Equal(t, time.Duration(-2), ttl)
+	})
+
+	t.Run("SetEX default expiration", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+
+		err := storage.SetEX(ctx, []byte("test-key"), []byte("value"))
+		assert.NoError(t, err)
+
+		ttl, err := storage.TTL(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour)
+	})
+
+	t.Run("Del", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+
+		deleted, err := storage.Del(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.False(t, deleted)
+
+		assert.NoError(t, storage.SetEX(ctx, []byte("test-key"), []byte("value"), time.Second))
+		deleted, err = storage.Del(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.True(t, deleted)
+		assert.Equal(t, 0, storage.Len())
+
+		// Expired keys are reported as missing
+		assert.NoError(t, storage.SetEX(ctx, []byte("test-key"), []byte("value"), time.Second))
+		clock.Advance(2 * time.Second)
+		deleted, err = storage.Del(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.False(t, deleted)
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+
+		ok, err := storage.Expire(ctx, []byte("test-key"), time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+
+		assert.NoError(t, storage.SetEX(ctx, []byte("test-key"), []byte("value"), time.Second))
+		ok, err = storage.Expire(ctx, []byte("test-key"), time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		clock.Advance(30 * time.Second)
+		ttl, err := storage.TTL(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.Equal(t, 30*time.Second, ttl)
+
+		// Value is kept
+		val, err := storage.Get(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("value"), val)
+	})
+
+	t.Run("SetNX", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+
+		ok, err := storage.SetNX(ctx, []byte("test-key"), []byte("first"), 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = storage.SetNX(ctx, []byte("test-key"), []byte("second"), 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+
+		val, err := storage.Get(ctx, []byte("test-key"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("first"), val)
+
+		// Key can be claimed again after expiration
+		clock.Advance(11 * time.Second)
+		ok, err = storage.SetNX(ctx, []byte("test-key"), []byte("third"), 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("CompareAndSwap", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+
+		matched, replaced, err := storage.CompareAndSwap(ctx, []byte("test-key"), []byte("first"), false)
+		assert.NoError(t, err)
+		assert.False(t, matched)
+		assert.False(t, replaced)
+		assert.Equal(t, 0, storage.Len())
+
+		matched, replaced, err = storage.CompareAndSwap(ctx, []byte("test-key"), []byte("first"), true, 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, matched)
+		assert.True(t, replaced)
+
+		matched, replaced, err = storage.CompareAndSwap(ctx, []byte("test-key"), []byte("first"), true, 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, matched)
+		assert.False(t, replaced)
+
+		matched, replaced, err = storage.CompareAndSwap(ctx, []byte("test-key"), []byte("second"), true, 10*time.Second)
+		assert.NoError(t, err)
+		assert.False(t, matched)
+		assert.True(t, replaced)
+
+		// An expired value no longer matches
+		clock.Advance(11 * time.Second)
+		matched, _, err = storage.CompareAndSwap(ctx, []byte("test-key"), []byte("second"), false)
+		assert.NoError(t, err)
+		assert.False(t, matched)
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+		assert.NoError(t, storage.SetEX(ctx, []byte("acme:1"), []byte("1"), time.Minute))
+		assert.NoError(t, storage.SetEX(ctx, []byte("acme:2"), []byte("1"), time.Second))
+		assert.NoError(t, storage.SetEX(ctx, []byte("globex:1"), []byte("1"), time.Minute))
+		clock.Advance(time.Second)
+
+		// expired keys are skipped and fn may delete the key it is given
+		var keys []string
+		err := storage.Scan(ctx, []byte("acme:"), func(key []byte) error {
+			keys = append(keys, string(key))
+			_, err := storage.Del(ctx, key)
+			return err
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"acme:1"}, keys)
+
+		exists, err := storage.Exists(ctx, []byte("acme:1"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+		assert.NoError(t, storage.SetEX(ctx, []byte("acme:1"), []byte("1"), time.Minute))
+		assert.NoError(t, storage.SetEX(ctx, []byte("acme:2"), []byte("1"), time.Minute))
+		assert.NoError(t, storage.SetEX(ctx, []byte("acme:3"), []byte("1"), time.Second))
+		assert.NoError(t, storage.SetEX(ctx, []byte("globex:1"), []byte("1"), time.Minute))
+		clock.Advance(time.Second)
+
+		// expired keys are dropped but not counted
+		purged, err := storage.Purge(ctx, []byte("acme:"))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), purged)
+		assert.Equal(t, 1, storage.Len())
+
+		exists, err := storage.Exists(ctx, []byte("globex:1"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("LRU eviction", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, MaxSize: 2})
+
+		assert.NoError(t, storage.SetEX(ctx, []byte("a"), []byte("1"), time.Minute))
+		assert.NoError(t, storage.SetEX(ctx, []byte("b"), []byte("2"), time.Minute))
+
+		// Touch "a" so "b" becomes the least recently used key
+		exists, err := storage.Exists(ctx, []byte("a"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+
+		assert.NoError(t, storage.SetEX(ctx, []byte("c"), []byte("3"), time.Minute))
+		assert.Equal(t, 2, storage.Len())
+
+		exists, err = storage.Exists(ctx, []byte("b"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+
+		exists, err = storage.Exists(ctx, []byte("a"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+
+		exists, err = storage.Exists(ctx, []byte("c"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("Sweep purges expired keys", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+
+		assert.NoError(t, storage.SetEX(ctx, []byte("short"), []byte("1"), time.Second))
+		assert.NoError(t, storage.SetEX(ctx, []byte("long"), []byte("2"), time.Minute))
+		assert.Equal(t, 2, storage.Len())
+
+		clock.Advance(2 * time.Second)
+		storage.Sweep()
+		assert.Equal(t, 1, storage.Len())
+	})
+
+	t.Run("Background sweeper", func(t *testing.T) {
+		clock := newFakeClock()
+		sweepCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		storage := NewMemoryStorage(sweepCtx, MemoryStorageConfig{SweepInterval: 5 * time.Millisecond, Now: clock.Now})
+		defer storage.Close()
+
+		assert.NoError(t, storage.SetEX(ctx, []byte("short"), []byte("1"), time.Second))
+		clock.Advance(2 * time.Second)
+
+		assert.Eventually(t, func() bool { return storage.Len() == 0 }, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("Concurrent access", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, MaxSize: 50})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					key := []byte(fmt.Sprintf("key-%d-%d", i, j%10))
+					assert.NoError(t, storage.SetEX(ctx, key, []byte("v"), time.Minute))
+					_, err := storage.Get(ctx, key)
+					assert.NoError(t, err)
+					_, err = storage.TTL(ctx, key)
+					assert.NoError(t, err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		assert.LessOrEqual(t, storage.Len(), 50)
+	})
+}
+
+// TestDeduperWithMemoryStorage runs the dedup flow against MemoryStorage
+func TestDeduperWithMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+
+	hashHandler := func(ctx context.Context, entity TestEntity) ([]byte, error) {
+		return []byte(entity.ID + ":" + entity.Name), nil
+	}
+
+	serializer := func(ctx context.Context, inputEntity any) (string, error) {
+		entity := inputEntity.(TestEntity)
+		return entity.ID + "|" + entity.Name, nil
+	}
+
+	deduper := NewDeduper(hashHandler, storage, NewMockLogger(), func() hash.Hash { return sha1.New() }, nil, serializer)
+	entity := TestEntity{ID: "123", Name: "Test"}
+
+	isDuplicate, err := deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+	assert.NoError(t, err)
+	assert.False(t, isDuplicate)
+
+	isDuplicate, err = deduper.IsDuplicate(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, isDuplicate)
+
+	isDuplicate, err = deduper.IsValueDuplicate(ctx, entity, DefaultHashStrategy())
+	assert.NoError(t, err)
+	assert.True(t, isDuplicate)
+
+	hash, err := deduper.Hash(ctx, entity, DefaultHashStrategy(), false)
+	assert.NoError(t, err)
+
+	ttl, err := deduper.TTL(ctx, hash)
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, ttl)
+
+	clock.Advance(11 * time.Second)
+
+	isDuplicate, err = deduper.IsDuplicate(ctx, entity, DefaultHashStrategy())
+	assert.NoError(t, err)
+	assert.False(t, isDuplicate)
+}
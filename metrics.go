@@ -0,0 +1,215 @@
+package dedup
+
+import (
+	"context"
+	stderrors "errors"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+)
+
+// MetricEvent is what a Metrics counter counts
+type MetricEvent string
+
+const (
+	// MetricCheck counts entities checked for duplicates
+	MetricCheck MetricEvent = "check"
+	// MetricDuplicate counts entities found to be duplicates
+	MetricDuplicate MetricEvent = "duplicate"
+	// MetricStore counts entities written to the storage
+	MetricStore MetricEvent = "store"
+	// MetricStorageError counts failed storage calls
+	MetricStorageError MetricEvent = "storage_error"
+	// MetricSerializerError counts failed value serializations
+	MetricSerializerError MetricEvent = "serializer_error"
+	// MetricMatcherError counts failed matcher calls
+	MetricMatcherError MetricEvent = "matcher_error"
+)
+
+// Deduper operations, used as the operation label of Metrics counters and the name of Tracer spans
+const (
+	OpIsDuplicate      = "is_duplicate"
+	OpIsValueDuplicate = "is_value_duplicate"
+	OpIsDuplicateN     = "is_duplicate_n"
+	OpIsNearDuplicate  = "is_near_duplicate"
+	OpClaim            = "claim"
+	OpStore            = "store"
+	OpStoreHash        = "store_hash"
+	OpIsDuplicateBatch = "is_duplicate_batch"
+	OpStoreBatch       = "store_batch"
+	OpBegin            = "begin"
+	OpComplete         = "complete"
+	OpForget           = "forget"
+	OpResult           = "result"
+	OpTTL              = "ttl"
+	OpPurge            = "purge"
+)
+
+// Metrics receives Deduper instrumentation, see WithMetrics.
+// Adapters live in the promdedup and oteldedup packages so the core stays dependency-free.
+type Metrics interface {
+	// Count adds n events of an operation on the Deduper with the given key prefix
+	Count(ctx context.Context, prefix string, operation string, event MetricEvent, n int)
+	// ObserveStorage records the latency of a storage call, such as "get" or "set_nx", and its error
+	ObserveStorage(ctx context.Context, prefix string, call string, duration time.Duration, err error)
+}
+
+// count adds n events when metrics are enabled; error events are also marked on the operation span
+func (d *Deduper) count(ctx context.Context, operation string, event MetricEvent, n int) {
+	if n <= 0 {
+		return
+	}
+	if event == MetricStorageError || event == MetricSerializerError || event == MetricMatcherError {
+		d.spanOf(ctx).mark(event)
+	}
+	if d.metrics == nil {
+		return
+	}
+	d.metrics.Count(ctx, string(d.prefix), operation, event, n)
+}
+
+// fail counts err by its event; errors that are none of storage, serializer or matcher are not counted
+func (d *Deduper) fail(ctx context.Context, operation string, err error) {
+	if event, ok := eventOf(err); ok {
+		d.count(ctx, operation, event, 1)
+	}
+}
+
+// classifiedError carries the event of an error its code does not tell apart, such as serializer
+// failures, which keep the DedupStorageErrorCode callers have always matched on
+type classifiedError struct {
+	error
+	event MetricEvent
+}
+
+func (e classifiedError) Unwrap() error {
+	return e.error
+}
+
+// eventOf returns the error event of err, by its classification or else by its error code
+func eventOf(err error) (MetricEvent, bool) {
+	var classified classifiedError
+	switch {
+	case err == nil:
+		return "", false
+	case stderrors.As(err, &classified):
+		return classified.event, true
+	case hasCode(err, DedupStorageErrorCode):
+		return MetricStorageError, true
+	case hasCode(err, DedupSerializerErrorCode):
+		return MetricSerializerError, true
+	}
+	return "", false
+}
+
+func hasCode(err error, code errors.ErrorCode) bool {
+	_, ok := errors.Has(err, code)
+	return ok
+}
+
+// metricsStorage times every storage call of a Deduper built WithMetrics
+type metricsStorage struct {
+	storage Storage
+	metrics Metrics
+	prefix  string
+}
+
+func (m *metricsStorage) observe(ctx context.Context, call string, start time.Time, err error) {
+	m.metrics.ObserveStorage(ctx, m.prefix, call, time.Since(start), err)
+}
+
+// Unwrap returns the timed storage so capability checks see through the decorator
+func (m *metricsStorage) Unwrap() Storage {
+	return m.storage
+}
+
+func (m *metricsStorage) Get(ctx context.Context, key []byte) ([]byte, error) {
+	start := time.Now()
+	value, err := m.storage.Get(ctx, key)
+	m.observe(ctx, "get", start, err)
+	return value, err
+}
+
+func (m *metricsStorage) TTL(ctx context.Context, key []byte) (time.Duration, error) {
+	start := time.Now()
+	ttl, err := m.storage.TTL(ctx, key)
+	m.observe(ctx, "ttl", start, err)
+	return ttl, err
+}
+
+func (m *metricsStorage) SetEX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error {
+	start := time.Now()
+	err := m.storage.SetEX(ctx, key, value, expiration...)
+	m.observe(ctx, "set_ex", start, err)
+	return err
+}
+
+func (m *metricsStorage) Exists(ctx context.Context, key []byte) (bool, error) {
+	start := time.Now()
+	exists, err := m.storage.Exists(ctx, key)
+	m.observe(ctx, "exists", start, err)
+	return exists, err
+}
+
+func (m *metricsStorage) Del(ctx context.Context, key []byte) (bool, error) {
+	start := time.Now()
+	deleted, err := m.storage.Del(ctx, key)
+	m.observe(ctx, "del", start, err)
+	return deleted, err
+}
+
+func (m *metricsStorage) SetNX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) (bool, error) {
+	start := time.Now()
+	ok, err := SetNX(ctx, m.storage, key, value, expirationOf(expiration))
+	m.observe(ctx, "set_nx", start, err)
+	return ok, err
+}
+
+func (m *metricsStorage) Expire(ctx context.Context, key []byte, expiration time.Duration) (bool, error) {
+	start := time.Now()
+	ok, err := Expire(ctx, m.storage, key, expiration)
+	m.observe(ctx, "expire", start, err)
+	return ok, err
+}
+
+func (m *metricsStorage) CompareAndSwap(ctx context.Context, key []byte, value []byte, replace bool, expiration ...time.Duration) (bool, bool, error) {
+	start := time.Now()
+	matched, replaced, err := CompareAndSwap(ctx, m.storage, key, value, replace, expirationOf(expiration))
+	m.observe(ctx, "compare_and_swap", start, err)
+	return matched, replaced, err
+}
+
+func (m *metricsStorage) ExistsBatch(ctx context.Context, keys [][]byte) ([]bool, error) {
+	start := time.Now()
+	exists, err := ExistsBatch(ctx, m.storage, keys)
+	m.observe(ctx, "exists_batch", start, err)
+	return exists, err
+}
+
+func (m *metricsStorage) SetEXBatch(ctx context.Context, keys [][]byte, values [][]byte, expiration ...time.Duration) error {
+	start := time.Now()
+	err := SetEXBatch(ctx, m.storage, keys, values, expirationOf(expiration))
+	m.observe(ctx, "set_ex_batch", start, err)
+	return err
+}
+
+func (m *metricsStorage) Incr(ctx context.Context, key []byte, expiration time.Duration) (int64, time.Duration, error) {
+	start := time.Now()
+	count, ttl, err := Incr(ctx, m.storage, key, expiration)
+	m.observe(ctx, "incr", start, err)
+	return count, ttl, err
+}
+
+func (m *metricsStorage) Scan(ctx context.Context, prefix []byte, fn func(key []byte) error) error {
+	start := time.Now()
+	err := Scan(ctx, m.storage, prefix, fn)
+	m.observe(ctx, "scan", start, err)
+	return err
+}
+
+func (m *metricsStorage) Purge(ctx context.Context, prefix []byte) (int64, error) {
+	start := time.Now()
+	purged, err := Purge(ctx, m.storage, prefix)
+	m.observe(ctx, "purge", start, err)
+	return purged, err
+}
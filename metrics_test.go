@@ -0,0 +1,119 @@
+package dedup
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingMetrics keeps every recorded event and storage call
+type recordingMetrics struct {
+	mu       sync.Mutex
+	events   map[string]int
+	calls    map[string]int
+	errors   map[string]int
+	prefixes map[string]struct{}
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{events: map[string]int{}, calls: map[string]int{}, errors: map[string]int{}, prefixes: map[string]struct{}{}}
+}
+
+func (r *recordingMetrics) Count(_ context.Context, prefix string, operation string, event MetricEvent, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prefixes[prefix] = struct{}{}
+	r.events[operation+"/"+string(event)] += n
+}
+
+func (r *recordingMetrics) ObserveStorage(_ context.Context, prefix string, call string, duration time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prefixes[prefix] = struct{}{}
+	r.calls[call]++
+	if err != nil {
+		r.errors[call]++
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	handler := func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }
+
+	t.Run("Checks, duplicates and stores", func(t *testing.T) {
+		metrics := newRecordingMetrics()
+		deduper := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}), WithMetrics(metrics), WithPrefix("orders:"))
+
+		for i := 0; i < 3; i++ {
+			_, err := deduper.IsDuplicate(ctx, "order-1", DefaultHashStrategy(), time.Minute)
+			assert.NoError(t, err)
+		}
+		_, errs := deduper.IsDuplicateBatch(ctx, []any{"order-1", "order-2", "order-2"}, DefaultHashStrategy(), time.Minute)
+		assert.Equal(t, []error{nil, nil, nil}, errs)
+
+		assert.Equal(t, map[string]int{
+			"is_duplicate/check":           3,
+			"is_duplicate/store":           1,
+			"is_duplicate/duplicate":       2,
+			"is_duplicate_batch/check":     3,
+			"is_duplicate_batch/duplicate": 2,
+			"is_duplicate_batch/store":     1,
+		}, metrics.events)
+		assert.Equal(t, map[string]struct{}{"orders:": {}}, metrics.prefixes)
+
+		// MemoryStorage is atomic, so IsDuplicate claims with SetNX
+		assert.Equal(t, 3, metrics.calls["set_nx"])
+		assert.Equal(t, 1, metrics.calls["exists_batch"])
+		assert.Equal(t, 1, metrics.calls["set_ex_batch"])
+	})
+
+	t.Run("Storage errors", func(t *testing.T) {
+		metrics := newRecordingMetrics()
+		storage := &MockStorage{
+			existsFunc: func(ctx context.Context, key []byte) (bool, error) { return false, assert.AnError },
+		}
+		deduper := New(handler, storage, WithMetrics(metrics))
+
+		_, err := deduper.IsDuplicate(ctx, "order-1", DefaultHashStrategy())
+		assert.Error(t, err)
+
+		assert.Equal(t, 1, metrics.events["is_duplicate/storage_error"])
+		assert.Equal(t, 1, metrics.errors["exists"])
+	})
+
+	t.Run("Serializer and matcher errors", func(t *testing.T) {
+		metrics := newRecordingMetrics()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		failing := func(context.Context, any) (string, error) { return "", assert.AnError }
+		deduper := New(handler, storage, WithMetrics(metrics), WithSerializer(failing))
+
+		_, err := deduper.Claim(ctx, "order-1", DefaultHashStrategy(), time.Minute)
+		assert.Error(t, err)
+		assert.Equal(t, 1, metrics.events["claim/serializer_error"])
+		assert.Equal(t, 0, metrics.events["claim/storage_error"])
+		// the error code is the one of earlier releases, only the metric tells serializers apart
+		assert.True(t, hasCode(err, DedupStorageErrorCode))
+
+		matcher := func(context.Context, any, any) (bool, error) { return false, assert.AnError }
+		deduper = New(handler, storage, WithMetrics(metrics), WithMatcher(matcher))
+		_, _, err = deduper.Store(ctx, "order-1", DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+
+		_, err = deduper.IsValueDuplicate(ctx, "order-1", DefaultHashStrategy())
+		assert.Error(t, err)
+		assert.Equal(t, 1, metrics.events["is_value_duplicate/matcher_error"])
+		assert.Equal(t, 0, metrics.events["is_value_duplicate/storage_error"])
+	})
+
+	t.Run("Capabilities are kept behind the metrics decorator", func(t *testing.T) {
+		deduper := New(handler, &MockStorage{}, WithMetrics(newRecordingMetrics()))
+		assert.False(t, supports[AtomicStorage](deduper.storage))
+
+		deduper = New(handler, NewMemoryStorage(ctx), WithMetrics(newRecordingMetrics()))
+		assert.True(t, supports[AtomicStorage](deduper.storage))
+		assert.True(t, supports[CompareAndSwapStorage](deduper.storage))
+	})
+}
@@ -0,0 +1,183 @@
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+)
+
+// PreviousKeys describes an earlier key derivation that WithMigration keeps reading
+type PreviousKeys struct {
+	// Version is the namespace version of the earlier keys, 0 for unversioned ones
+	Version int
+	// Prefix is the earlier key prefix without its version, defaults to the current one
+	Prefix string
+	// Handler is the earlier key handler, see KeyHandler; defaults to the current one
+	Handler func(ctx context.Context, entity any) ([]byte, error)
+	// Strategy is the HashStrategy the earlier keys were built with, defaults to the current default strategy
+	Strategy HashStrategy
+	// Encoding is the KeyEncoding the earlier keys were built with
+	Encoding KeyEncoding
+}
+
+// Migration configures the dual-read of WithMigration
+type Migration struct {
+	// Previous lists the earlier key derivations, checked in order after the current key
+	Previous []PreviousKeys
+	// Rewrite copies an entry found under an earlier key to the current key, keeping its TTL,
+	// so it is found with a single lookup next time
+	Rewrite bool
+	// Until is the cut-over time after which earlier keys are ignored; zero never cuts over
+	Until time.Time
+	// Now returns the current time, defaults to time.Now; inject a fake clock for deterministic cut-over
+	Now func() time.Time
+}
+
+// migration is a Migration with the earlier prefixes resolved by New
+type migration struct {
+	Migration
+	prefixes [][]byte
+}
+
+// versionedPrefix appends the namespace version to prefix, "dedup:orders:" becomes "dedup:orders:v2:"
+func versionedPrefix(prefix string, version int) string {
+	if version == 0 {
+		return prefix
+	}
+	return fmt.Sprintf("%sv%d:", prefix, version)
+}
+
+// resolveMigration fixes the earlier prefixes and strategies once the options of New are applied
+func (d *Deduper) resolveMigration(prefix string) {
+	if d.migration == nil {
+		return
+	}
+	if d.migration.Now == nil {
+		d.migration.Now = time.Now
+	}
+
+	// copied so the defaults never leak into the caller's config
+	d.migration.Previous = slices.Clone(d.migration.Previous)
+	for i, previous := range d.migration.Previous {
+		if previous.Strategy == (HashStrategy{}) {
+			d.migration.Previous[i].Strategy = d.strategy
+		}
+
+		base := prefix
+		if previous.Prefix != "" {
+			base = previous.Prefix
+		}
+		base = versionedPrefix(base, previous.Version)
+		if d.hashTag {
+			base = HashTag(base)
+		}
+		d.migration.prefixes = append(d.migration.prefixes, []byte(base))
+	}
+}
+
+// migrating reports whether earlier keys are still read
+func (d *Deduper) migrating() bool {
+	if d.migration == nil || len(d.migration.Previous) == 0 {
+		return false
+	}
+	return d.migration.Until.IsZero() || d.migration.Now().Before(d.migration.Until)
+}
+
+// previousKeys derives the earlier keys of the entity
+func (d *Deduper) previousKeys(ctx context.Context, entity any) ([][]byte, error) {
+	keys := make([][]byte, 0, len(d.migration.Previous))
+	for i, previous := range d.migration.Previous {
+		handler := previous.Handler
+		if handler == nil {
+			handler = d.handler
+		}
+
+		input, err := handler(ctx, entity)
+		if err != nil {
+			return nil, err
+		}
+
+		hash := encodeKey(previous.Encoding, input, false)
+		if shouldHash(previous.Strategy.KeyHashMode, previous.Strategy.KeyThreshold, len(input)) {
+			h := d.hasher()
+			h.Write(input)
+			hash = encodeKey(previous.Encoding, h.Sum(nil), true)
+		}
+
+		prefix := d.migration.prefixes[i]
+		if scope := d.scopeOf(ctx); scope != "" {
+			prefix = scopePrefix(prefix, scope)
+		}
+		key := make([]byte, 0, len(prefix)+len(hash))
+		keys = append(keys, append(append(key, prefix...), hash...))
+	}
+	return keys, nil
+}
+
+// migrated looks the entity up under its earlier keys when the current key holds nothing,
+// returning the value of the first one found, rewritten under key with Migration.Rewrite
+func (d *Deduper) migrated(ctx context.Context, entity any, key []byte) ([]byte, error) {
+	if !d.migrating() {
+		return nil, nil
+	}
+
+	keys, err := d.previousKeys(ctx, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, previous := range keys {
+		value, err := d.storage.Get(ctx, previous)
+		if err != nil {
+			return nil, errors.Wrap(err, "storage error for previous key; %s", err.Error(), DedupStorageErrorCode)
+		}
+		if IsEmpty(value) {
+			continue
+		}
+
+		if d.migration.Rewrite {
+			d.rewrite(ctx, previous, key, value)
+		}
+		return value, nil
+	}
+	return nil, nil
+}
+
+// rewrite copies value to key with the remaining TTL of previous, failures are logged
+func (d *Deduper) rewrite(ctx context.Context, previous []byte, key []byte, value []byte) {
+	ttl, err := d.storage.TTL(ctx, previous)
+	if err != nil || ttl <= 0 {
+		_, ttl = d.defaults(ctx)
+	}
+
+	_, err = SetNX(ctx, d.storage, key, value, ttl)
+	if err != nil {
+		d.logger.With("error", err).Error("failed to rewrite previous key; %s", err.Error())
+	}
+}
+
+// forgetMigrated removes the earlier keys of the entity, reporting whether any existed
+func (d *Deduper) forgetMigrated(ctx context.Context, entity any) (bool, error) {
+	if !d.migrating() {
+		return false, nil
+	}
+
+	keys, err := d.previousKeys(ctx, entity)
+	if err != nil {
+		return false, err
+	}
+
+	forgotten := false
+	for _, previous := range keys {
+		deleted, err := d.storage.Del(ctx, previous)
+		if err != nil {
+			d.count(ctx, OpForget, MetricStorageError, 1)
+			return false, errors.Wrap(err, "storage error for previous key; %s", err.Error(), DedupStorageErrorCode)
+		}
+		forgotten = forgotten || deleted
+	}
+	return forgotten, nil
+}
@@ -0,0 +1,200 @@
+package dedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigration(t *testing.T) {
+	ctx := context.Background()
+	byID := func(_ context.Context, e TestEntity) ([]byte, error) { return []byte(e.ID), nil }
+	byIDAndName := func(_ context.Context, e TestEntity) ([]byte, error) { return []byte(e.ID + "/" + e.Name), nil }
+	entity := TestEntity{ID: "order-1", Name: "Order"}
+	strategy := DefaultHashStrategy()
+
+	t.Run("Versioned prefix", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		d := New(byID, storage, WithPrefix("orders:"), WithVersion(2))
+
+		_, key, err := d.Store(ctx, entity, strategy, time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, "orders:v2:order-1", string(key))
+
+		tagged := New(byID, storage, WithPrefix("orders:"), WithVersion(2), WithHashTag())
+		_, key, err = tagged.Store(ctx, entity, strategy, time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, "{orders:v2}:order-1", string(key))
+		assert.Equal(t, "{orders:v2}:", tagged.Prefix())
+	})
+
+	t.Run("Dual read", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		v1 := New(byID, storage, WithPrefix("orders:"))
+		_, err := v1.IsDuplicate(ctx, entity, strategy, time.Minute)
+		assert.NoError(t, err)
+
+		// a new handler and version hide the existing entries
+		v2 := New(byIDAndName, storage, WithPrefix("orders:"), WithVersion(2))
+		isDuplicate, err := v2.IsDuplicate(ctx, entity, strategy)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		migration := Migration{Previous: []PreviousKeys{{Handler: KeyHandler(byID), Strategy: strategy}}}
+		v2 = New(byIDAndName, storage, WithPrefix("orders:"), WithVersion(2), WithMigration(migration))
+
+		isDuplicate, err = v2.IsDuplicate(ctx, entity, strategy)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		isDuplicate, err = v2.IsValueDuplicate(ctx, entity, strategy)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		results, errs := v2.IsDuplicateBatch(ctx, []any{entity, TestEntity{ID: "order-3", Name: "Order"}}, strategy)
+		assert.Equal(t, []error{nil, nil}, errs)
+		assert.Equal(t, []bool{true, false}, results)
+
+		claimed, err := v2.Claim(ctx, entity, strategy, time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, claimed)
+
+		// Claim takes the current key before reading the previous ones, so it holds the entity now
+		hash, err := v2.Hash(ctx, entity, strategy, false)
+		assert.NoError(t, err)
+		exists, err := storage.Exists(ctx, v2.buildKey(ctx, hash))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+
+		other := TestEntity{ID: "order-2", Name: "Order"}
+		isDuplicate, err = v2.IsDuplicate(ctx, other, strategy, time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+
+	t.Run("Rewrite on hit", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+		v1 := New(byID, storage, WithPrefix("orders:"))
+		_, _, err := v1.Store(ctx, entity, strategy, time.Minute)
+		assert.NoError(t, err)
+		clock.Advance(20 * time.Second)
+
+		migration := Migration{
+			Previous: []PreviousKeys{{Strategy: strategy}},
+			Rewrite:  true,
+			Now:      clock.Now,
+		}
+		v2 := New(byID, storage, WithPrefix("orders:"), WithVersion(2), WithMigration(migration))
+
+		isDuplicate, err := v2.IsDuplicate(ctx, entity, strategy)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		// the rewritten entry keeps the value and remaining TTL of the previous one
+		hash, err := v2.Hash(ctx, entity, strategy, false)
+		assert.NoError(t, err)
+		value, err := storage.Get(ctx, []byte("orders:v2:order-1"))
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"ID":"order-1","Name":"Order"}`, string(value))
+		ttl, err := v2.TTL(ctx, hash)
+		assert.NoError(t, err)
+		assert.Equal(t, 40*time.Second, ttl)
+	})
+
+	t.Run("Cut-over", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+		v1 := New(byID, storage, WithPrefix("orders:"))
+		_, _, err := v1.Store(ctx, entity, strategy, time.Hour)
+		assert.NoError(t, err)
+
+		migration := Migration{
+			Previous: []PreviousKeys{{Strategy: strategy}},
+			Until:    clock.Now().Add(time.Minute),
+			Now:      clock.Now,
+		}
+		v2 := New(byID, storage, WithPrefix("orders:"), WithVersion(2), WithMigration(migration))
+
+		isDuplicate, err := v2.IsDuplicate(ctx, entity, strategy)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		clock.Advance(time.Minute)
+		isDuplicate, err = v2.IsDuplicate(ctx, entity, strategy)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+
+	t.Run("Several previous derivations", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		legacy := New(byID, storage, WithPrefix("legacy:"), WithDefaultStrategy(HashStrategy{KeyHashMode: AlwaysHash}))
+		_, err := legacy.IsDuplicate(ctx, entity, legacy.DefaultStrategy(), time.Minute)
+		assert.NoError(t, err)
+
+		migration := Migration{Previous: []PreviousKeys{
+			{Version: 1, Strategy: strategy},
+			{Prefix: "legacy:", Strategy: HashStrategy{KeyHashMode: AlwaysHash}},
+		}}
+		v2 := New(byIDAndName, storage, WithPrefix("orders:"), WithVersion(2), WithMigration(migration))
+
+		// the default handler of the previous derivations is the current one
+		isDuplicate, err := v2.IsDuplicate(ctx, TestEntity{ID: "order-1"}, strategy)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		migration.Previous[1].Handler = KeyHandler(byID)
+		v2 = New(byIDAndName, storage, WithPrefix("orders:"), WithVersion(2), WithMigration(migration))
+		isDuplicate, err = v2.IsDuplicate(ctx, entity, strategy)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		// Forget removes the previous keys too
+		assert.NoError(t, v2.Forget(ctx, entity, strategy))
+		isDuplicate, err = legacy.IsDuplicate(ctx, entity, legacy.DefaultStrategy())
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+
+	t.Run("Previous strategy defaults to the current one", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		v1 := New(byID, storage, WithPrefix("orders:"))
+		_, err := v1.IsDuplicate(ctx, entity, strategy, time.Minute)
+		assert.NoError(t, err)
+
+		migration := Migration{Previous: []PreviousKeys{{Version: 0}}}
+		v2 := New(byID, storage, WithPrefix("orders:"), WithVersion(2), WithMigration(migration))
+		assert.Equal(t, HashStrategy{}, migration.Previous[0].Strategy)
+
+		isDuplicate, err := v2.IsDuplicate(ctx, entity, strategy)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		results, errs := v2.IsDuplicateBatch(ctx, []any{entity}, strategy, time.Minute)
+		assert.Equal(t, []error{nil}, errs)
+		assert.Equal(t, []bool{true}, results)
+	})
+
+	t.Run("Atomic storage", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		storage := NewRedisStorage(ctx, redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+		v1 := New(byID, storage, WithPrefix("orders:"))
+		_, err := v1.IsDuplicate(ctx, entity, strategy, time.Minute)
+		assert.NoError(t, err)
+
+		migration := Migration{Previous: []PreviousKeys{{Strategy: strategy}}}
+		v2 := New(byID, storage, WithPrefix("orders:"), WithVersion(2), WithMigration(migration))
+
+		isDuplicate, err := v2.IsDuplicate(ctx, entity, strategy, time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		isDuplicate, err = v2.IsValueDuplicate(ctx, TestEntity{ID: "order-1", Name: "Renamed"}, strategy)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+}
@@ -0,0 +1,216 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"hash"
+	"time"
+
+	"github.com/pixie-sh/logger-go/logger"
+)
+
+// DefaultTTL is the expiration used by Deduper built with New unless WithDefaultTTL is given
+const DefaultTTL = time.Hour
+
+// Option configures a Deduper built with New
+type Option func(d *Deduper)
+
+// WithLogger sets the logger, defaults to a no-op logger
+func WithLogger(logger logger.Interface) Option {
+	return func(d *Deduper) {
+		d.logger = logger
+	}
+}
+
+// WithHasher sets the hash function used for keys and values, defaults to sha256
+func WithHasher(hasher func() hash.Hash) Option {
+	return func(d *Deduper) {
+		d.hasher = hasher
+	}
+}
+
+// WithMatcher sets the matcher used by IsValueDuplicate, see TypedMatcher for a typed one
+func WithMatcher(matcher matchHandler) Option {
+	return func(d *Deduper) {
+		d.matcher = matcher
+	}
+}
+
+// WithSerializer sets the value serializer, defaults to JSON; see TypedSerializer for a typed one
+// and the serialize package for ready-made ones. Serializers returning []byte avoid a string copy.
+func WithSerializer[S string | []byte](serializer func(ctx context.Context, entity any) (S, error)) Option {
+	return func(d *Deduper) {
+		switch fn := any(serializer).(type) {
+		case Serializer:
+			d.serializer = fn
+		case serializeHandler:
+			d.serializer = bytesSerializer(fn)
+		}
+	}
+}
+
+// WithPrefix sets the key prefix, defaults to "dedup:<type>:"
+func WithPrefix(prefix string) Option {
+	return func(d *Deduper) {
+		d.prefix = []byte(prefix)
+	}
+}
+
+// WithDefaultStrategy sets the strategy returned by DefaultStrategy and used by Seen
+func WithDefaultStrategy(strategy HashStrategy) Option {
+	return func(d *Deduper) {
+		d.strategy = strategy
+	}
+}
+
+// WithDefaultTTL sets the expiration returned by DefaultTTL and used by Seen
+func WithDefaultTTL(ttl time.Duration) Option {
+	return func(d *Deduper) {
+		d.ttl = ttl
+	}
+}
+
+// WithSlidingWindow makes IsDuplicate refresh the key expiration on every duplicate hit,
+// so a key stays alive while duplicates keep coming. The refreshed expiration is the
+// storeIfNot duration when given, the default TTL otherwise.
+func WithSlidingWindow() Option {
+	return func(d *Deduper) {
+		d.sliding = true
+	}
+}
+
+// WithHashTag wraps the key prefix in a Redis Cluster hash tag, see HashTag.
+// It applies to the final prefix regardless of the option order.
+func WithHashTag() Option {
+	return func(d *Deduper) {
+		d.hashTag = true
+	}
+}
+
+// WithMetrics records counters for every operation and storage call latencies on metrics,
+// labelled by the final key prefix
+func WithMetrics(metrics Metrics) Option {
+	return func(d *Deduper) {
+		d.metrics = metrics
+	}
+}
+
+// WithTracer wraps IsDuplicate, IsValueDuplicate, Store, StoreHash and TTL in spans started
+// by tracer from the ctx passed to each call. Storage calls are traced by wrapping the
+// Storage, see oteldedup.NewTracingStorage.
+func WithTracer(tracer Tracer) Option {
+	return func(d *Deduper) {
+		d.tracer = tracer
+	}
+}
+
+// WithSimilarity configures the near-duplicate detection of IsNearDuplicate, FindSimilar
+// and StoreFingerprint; without it they use SimHash on the serialized entity
+func WithSimilarity(config SimilarityConfig) Option {
+	return func(d *Deduper) {
+		d.similarity = config
+	}
+}
+
+// WithKeyEncoding sets how key hashes are laid out, defaults to LegacyKeys. The tagged encodings
+// keep raw inputs and digests apart under AutoSmart; switching to one changes every key,
+// see WithLegacyKeyFallback to keep existing entries visible.
+func WithKeyEncoding(encoding KeyEncoding) Option {
+	return func(d *Deduper) {
+		d.keyEncoding = encoding
+	}
+}
+
+// WithLegacyKeyFallback makes IsDuplicate, IsDuplicateBatch, IsValueDuplicate, Claim, TTL and ForgetHash
+// also look at the untagged LegacyKeys shape of a key while entries written before
+// WithKeyEncoding expire. New entries are only written under the tagged key.
+func WithLegacyKeyFallback() Option {
+	return func(d *Deduper) {
+		d.legacyKeys = true
+	}
+}
+
+// WithVersion adds a namespace version to the key prefix, "dedup:orders:" becomes "dedup:orders:v2:".
+// Bump it when the key handler or HashStrategy changes, see WithMigration to keep reading the
+// previous version.
+func WithVersion(version int) Option {
+	return func(d *Deduper) {
+		d.version = version
+	}
+}
+
+// WithMigration makes lookups that miss the current key, IsDuplicateBatch included, also check the earlier key derivations
+// of config, until its cut-over time. Entries found there count as duplicates and are
+// optionally rewritten under the current key. Forget removes the earlier keys as well.
+func WithMigration(config Migration) Option {
+	return func(d *Deduper) {
+		d.migration = &migration{Migration: config}
+	}
+}
+
+// WithScopeDefaults overrides the default strategy and TTL for calls scoped to scope,
+// see ContextWithScope and Scoped; repeat it for every scope with its own defaults
+func WithScopeDefaults(scope string, defaults ScopeDefaults) Option {
+	return func(d *Deduper) {
+		if d.scopes == nil {
+			d.scopes = make(map[string]ScopeDefaults)
+		}
+		d.scopes[scope] = defaults
+	}
+}
+
+// New creates a Deduper from a key handler and a Storage; everything else is optional.
+// Defaults are a no-op logger, sha256 hashing, JSON serialization, no matcher,
+// DefaultHashStrategy and DefaultTTL.
+func New[T any](handler func(ctx context.Context, t T) ([]byte, error), storage Storage, opts ...Option) *Deduper {
+	d := NewDeduper(handler, storage, nopLogger{}, sha256.New, nil, nil)
+	d.serializer = jsonSerializer
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.resolveMigration(string(d.prefix))
+	d.prefix = []byte(versionedPrefix(string(d.prefix), d.version))
+	if d.hashTag {
+		d.prefix = []byte(HashTag(string(d.prefix)))
+	}
+	if d.metrics != nil {
+		d.storage = &metricsStorage{storage: d.storage, metrics: d.metrics, prefix: string(d.prefix)}
+	}
+	return d
+}
+
+// NewTyped creates a TypedDeduper using the same options as New
+func NewTyped[T any](handler func(ctx context.Context, t T) ([]byte, error), storage Storage, opts ...Option) *TypedDeduper[T] {
+	return &TypedDeduper[T]{deduper: New(handler, storage, opts...)}
+}
+
+func jsonSerializer(_ context.Context, entity any) ([]byte, error) {
+	return json.Marshal(entity)
+}
+
+// bytesSerializer adapts a string serializer to Serializer
+func bytesSerializer(serializer serializeHandler) Serializer {
+	if serializer == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, entity any) ([]byte, error) {
+		ser, err := serializer(ctx, entity)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(ser), nil
+	}
+}
+
+// nopLogger discards every log line
+type nopLogger struct{}
+
+func (n nopLogger) Clone() logger.Interface                    { return n }
+func (n nopLogger) WithCtx(_ context.Context) logger.Interface { return n }
+func (n nopLogger) With(_ string, _ any) logger.Interface      { return n }
+func (n nopLogger) Log(_ string, _ ...any)                     {}
+func (n nopLogger) Error(_ string, _ ...any)                   {}
+func (n nopLogger) Warn(_ string, _ ...any)                    {}
+func (n nopLogger) Debug(_ string, _ ...any)                   {}
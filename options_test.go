@@ -0,0 +1,133 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"hash"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	ctx := context.Background()
+
+	hashHandler := func(ctx context.Context, entity TestEntity) ([]byte, error) {
+		return []byte(entity.ID + ":" + entity.Name), nil
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		deduper := New(hashHandler, storage)
+		assert.NotNil(t, deduper)
+
+		assert.Equal(t, DefaultHashStrategy(), deduper.DefaultStrategy())
+		assert.Equal(t, DefaultTTL, deduper.DefaultTTL())
+
+		// sha256 is used above the key threshold
+		entity := TestEntity{ID: "1234567890", Name: "a name long enough to be hashed"}
+		dedupHash, err := deduper.Hash(ctx, entity, DefaultHashStrategy(), false)
+		assert.NoError(t, err)
+		sum := sha256.Sum256([]byte(entity.ID + ":" + entity.Name))
+		assert.Equal(t, sum[:], dedupHash)
+
+		// JSON serialization is stored under the default prefix
+		_, key, err := deduper.Store(ctx, entity, HashStrategy{ValueHashMode: NeverHash}, time.Minute)
+		assert.NoError(t, err)
+		assert.Contains(t, string(key), "dedup:dedup.TestEntity:")
+
+		val, err := storage.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"ID":"1234567890","Name":"a name long enough to be hashed"}`, string(val))
+	})
+
+	t.Run("Options override defaults", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		log := NewMockLogger()
+		strategy := HashStrategy{KeyHashMode: AlwaysHash, ValueHashMode: NeverHash}
+		matched := false
+
+		deduper := New(hashHandler, storage,
+			WithLogger(log),
+			WithHasher(func() hash.Hash { return sha1.New() }),
+			WithMatcher(TypedMatcher(func(ctx context.Context, input TestEntity, stored string) (bool, error) {
+				matched = true
+				return stored == input.ID, nil
+			})),
+			WithSerializer(TypedSerializer(func(ctx context.Context, input TestEntity) (string, error) {
+				return input.ID, nil
+			})),
+			WithPrefix("custom:"),
+			WithDefaultStrategy(strategy),
+			WithDefaultTTL(5*time.Second),
+		)
+
+		assert.Equal(t, strategy, deduper.DefaultStrategy())
+		assert.Equal(t, 5*time.Second, deduper.DefaultTTL())
+
+		entity := TestEntity{ID: "123", Name: "Test"}
+		dedupHash, key, err := deduper.Store(ctx, entity, strategy, time.Minute)
+		assert.NoError(t, err)
+
+		sum := sha1.Sum([]byte("123:Test"))
+		assert.Equal(t, sum[:], dedupHash)
+		assert.Equal(t, "custom:"+string(sum[:]), string(key))
+
+		isDuplicate, err := deduper.IsValueDuplicate(ctx, entity, strategy)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+		assert.True(t, matched)
+	})
+
+	t.Run("Seen uses the default strategy and TTL", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+		deduper := New(hashHandler, storage, WithDefaultTTL(5*time.Second))
+
+		entity := TestEntity{ID: "123", Name: "Test"}
+
+		seen, err := deduper.Seen(ctx, entity)
+		assert.NoError(t, err)
+		assert.False(t, seen)
+
+		seen, err = deduper.Seen(ctx, entity)
+		assert.NoError(t, err)
+		assert.True(t, seen)
+
+		clock.Advance(6 * time.Second)
+
+		seen, err = deduper.Seen(ctx, entity)
+		assert.NoError(t, err)
+		assert.False(t, seen)
+	})
+
+	t.Run("NewTyped", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		deduper := NewTyped(hashHandler, storage, WithPrefix("typed:"))
+
+		entity := TestEntity{ID: "123", Name: "Test"}
+		_, key, err := deduper.Store(ctx, entity, DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, "typed:123:Test", string(key))
+
+		val, err := storage.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"ID":"123","Name":"Test"}`, string(val))
+	})
+
+	t.Run("Byte serializers", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		deduper := New(hashHandler, storage, WithSerializer(func(_ context.Context, entity any) ([]byte, error) {
+			return []byte(entity.(TestEntity).Name), nil
+		}))
+
+		_, key, err := deduper.Store(ctx, TestEntity{ID: "123", Name: "Test"}, DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+
+		val, err := storage.Get(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, "Test", string(val))
+	})
+}
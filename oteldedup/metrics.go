@@ -0,0 +1,64 @@
+// Package oteldedup provides OpenTelemetry adapters for dedup
+package oteldedup
+
+import (
+	"context"
+	"time"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/pixie-sh/errors-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Metrics implements dedup.Metrics with OpenTelemetry instruments:
+// the dedup.events counter and the dedup.storage.duration histogram, in seconds
+type Metrics struct {
+	events  metric.Int64Counter
+	storage metric.Float64Histogram
+}
+
+var _ dedup.Metrics = (*Metrics)(nil)
+
+// NewMetrics creates the instruments on meter
+func NewMetrics(meter metric.Meter) (*Metrics, error) {
+	events, err := meter.Int64Counter("dedup.events",
+		metric.WithDescription("Deduper checks, duplicates, stores and errors by key prefix and operation."),
+		metric.WithUnit("{event}"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create dedup.events counter; %s", err.Error())
+	}
+
+	storage, err := meter.Float64Histogram("dedup.storage.duration",
+		metric.WithDescription("Latency of the storage calls made by a Deduper."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create dedup.storage.duration histogram; %s", err.Error())
+	}
+
+	return &Metrics{events: events, storage: storage}, nil
+}
+
+// Count adds n events
+func (m *Metrics) Count(ctx context.Context, prefix string, operation string, event dedup.MetricEvent, n int) {
+	m.events.Add(ctx, int64(n), metric.WithAttributes(
+		attribute.String("dedup.prefix", prefix),
+		attribute.String("dedup.operation", operation),
+		attribute.String("dedup.event", string(event)),
+	))
+}
+
+// ObserveStorage records a storage call latency, with dedup.outcome "ok" or "error"
+func (m *Metrics) ObserveStorage(ctx context.Context, prefix string, call string, duration time.Duration, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	m.storage.Record(ctx, duration.Seconds(), metric.WithAttributes(
+		attribute.String("dedup.prefix", prefix),
+		attribute.String("dedup.storage.call", call),
+		attribute.String("dedup.outcome", outcome),
+	))
+}
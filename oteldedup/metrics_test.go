@@ -0,0 +1,60 @@
+package oteldedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	metrics, err := NewMetrics(provider.Meter("dedup"))
+	assert.NoError(t, err)
+
+	handler := func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }
+	storage := dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})
+	deduper := dedup.New(handler, storage, dedup.WithMetrics(metrics), dedup.WithPrefix("orders:"))
+
+	for i := 0; i < 3; i++ {
+		_, err := deduper.IsDuplicate(ctx, "order-1", dedup.DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+	}
+
+	var data metricdata.ResourceMetrics
+	assert.NoError(t, reader.Collect(ctx, &data))
+	assert.Len(t, data.ScopeMetrics, 1)
+
+	events := map[string]int64{}
+	var observations uint64
+	for _, m := range data.ScopeMetrics[0].Metrics {
+		switch agg := m.Data.(type) {
+		case metricdata.Sum[int64]:
+			assert.Equal(t, "dedup.events", m.Name)
+			for _, point := range agg.DataPoints {
+				prefix, _ := point.Attributes.Value(attribute.Key("dedup.prefix"))
+				assert.Equal(t, "orders:", prefix.AsString())
+				event, _ := point.Attributes.Value(attribute.Key("dedup.event"))
+				events[event.AsString()] += point.Value
+			}
+		case metricdata.Histogram[float64]:
+			assert.Equal(t, "dedup.storage.duration", m.Name)
+			for _, point := range agg.DataPoints {
+				call, _ := point.Attributes.Value(attribute.Key("dedup.storage.call"))
+				assert.Equal(t, "set_nx", call.AsString())
+				observations += point.Count
+			}
+		}
+	}
+
+	assert.Equal(t, map[string]int64{"check": 3, "duplicate": 2, "store": 1}, events)
+	assert.Equal(t, uint64(3), observations)
+}
@@ -0,0 +1,205 @@
+package oteldedup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer implements dedup.Tracer with an OpenTelemetry tracer; spans are named "dedup.<operation>"
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+var _ dedup.Tracer = (*Tracer)(nil)
+
+// NewTracer adapts tracer, see dedup.WithTracer
+func NewTracer(tracer trace.Tracer) *Tracer {
+	return &Tracer{tracer: tracer}
+}
+
+// Start starts an internal span as a child of the span in ctx
+func (t *Tracer) Start(ctx context.Context, operation string) (context.Context, dedup.Span) {
+	ctx, span := t.tracer.Start(ctx, "dedup."+operation, trace.WithSpanKind(trace.SpanKindInternal))
+	return ctx, otelSpan{span: span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) SetAttribute(key string, value any) {
+	switch v := value.(type) {
+	case string:
+		s.span.SetAttributes(attribute.String(key, v))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, v))
+	case int:
+		s.span.SetAttributes(attribute.Int(key, v))
+	case int64:
+		s.span.SetAttributes(attribute.Int64(key, v))
+	case float64:
+		s.span.SetAttributes(attribute.Float64(key, v))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+	}
+}
+
+func (s otelSpan) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+func (s otelSpan) End() {
+	s.span.End()
+}
+
+// TracingStorage is a dedup.Storage decorator that traces every backend call in a client span
+// named "dedup.storage.<call>", such as "dedup.storage.set_nx", with the dedup.storage.call attribute.
+// Optional capabilities of the wrapped Storage are kept.
+type TracingStorage struct {
+	storage dedup.Storage
+	tracer  trace.Tracer
+}
+
+var (
+	_ dedup.AtomicStorage         = (*TracingStorage)(nil)
+	_ dedup.BatchStorage          = (*TracingStorage)(nil)
+	_ dedup.ExpireStorage         = (*TracingStorage)(nil)
+	_ dedup.CompareAndSwapStorage = (*TracingStorage)(nil)
+	_ dedup.CounterStorage        = (*TracingStorage)(nil)
+	_ dedup.ScanStorage           = (*TracingStorage)(nil)
+	_ dedup.PurgeStorage          = (*TracingStorage)(nil)
+)
+
+// NewTracingStorage wraps storage, starting spans on tracer
+func NewTracingStorage(storage dedup.Storage, tracer trace.Tracer) *TracingStorage {
+	return &TracingStorage{storage: storage, tracer: tracer}
+}
+
+// Unwrap returns the traced storage so capability checks see through the decorator
+func (s *TracingStorage) Unwrap() dedup.Storage {
+	return s.storage
+}
+
+func (s *TracingStorage) start(ctx context.Context, call string, keys int) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "dedup.storage."+call,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("dedup.storage.call", call),
+			attribute.Int("dedup.storage.keys", keys),
+		),
+	)
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *TracingStorage) Get(ctx context.Context, key []byte) ([]byte, error) {
+	ctx, span := s.start(ctx, "get", 1)
+	value, err := s.storage.Get(ctx, key)
+	end(span, err)
+	return value, err
+}
+
+func (s *TracingStorage) TTL(ctx context.Context, key []byte) (time.Duration, error) {
+	ctx, span := s.start(ctx, "ttl", 1)
+	ttl, err := s.storage.TTL(ctx, key)
+	end(span, err)
+	return ttl, err
+}
+
+func (s *TracingStorage) SetEX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error {
+	ctx, span := s.start(ctx, "set_ex", 1)
+	err := s.storage.SetEX(ctx, key, value, expiration...)
+	end(span, err)
+	return err
+}
+
+func (s *TracingStorage) Exists(ctx context.Context, key []byte) (bool, error) {
+	ctx, span := s.start(ctx, "exists", 1)
+	exists, err := s.storage.Exists(ctx, key)
+	end(span, err)
+	return exists, err
+}
+
+func (s *TracingStorage) Del(ctx context.Context, key []byte) (bool, error) {
+	ctx, span := s.start(ctx, "del", 1)
+	deleted, err := s.storage.Del(ctx, key)
+	end(span, err)
+	return deleted, err
+}
+
+func (s *TracingStorage) SetNX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) (bool, error) {
+	ctx, span := s.start(ctx, "set_nx", 1)
+	ok, err := dedup.SetNX(ctx, s.storage, key, value, expirationOf(expiration))
+	end(span, err)
+	return ok, err
+}
+
+func (s *TracingStorage) Expire(ctx context.Context, key []byte, expiration time.Duration) (bool, error) {
+	ctx, span := s.start(ctx, "expire", 1)
+	ok, err := dedup.Expire(ctx, s.storage, key, expiration)
+	end(span, err)
+	return ok, err
+}
+
+func (s *TracingStorage) CompareAndSwap(ctx context.Context, key []byte, value []byte, replace bool, expiration ...time.Duration) (bool, bool, error) {
+	ctx, span := s.start(ctx, "compare_and_swap", 1)
+	matched, replaced, err := dedup.CompareAndSwap(ctx, s.storage, key, value, replace, expirationOf(expiration))
+	end(span, err)
+	return matched, replaced, err
+}
+
+func (s *TracingStorage) Incr(ctx context.Context, key []byte, expiration time.Duration) (int64, time.Duration, error) {
+	ctx, span := s.start(ctx, "incr", 1)
+	count, ttl, err := dedup.Incr(ctx, s.storage, key, expiration)
+	end(span, err)
+	return count, ttl, err
+}
+
+func (s *TracingStorage) ExistsBatch(ctx context.Context, keys [][]byte) ([]bool, error) {
+	ctx, span := s.start(ctx, "exists_batch", len(keys))
+	exists, err := dedup.ExistsBatch(ctx, s.storage, keys)
+	end(span, err)
+	return exists, err
+}
+
+func (s *TracingStorage) SetEXBatch(ctx context.Context, keys [][]byte, values [][]byte, expiration ...time.Duration) error {
+	ctx, span := s.start(ctx, "set_ex_batch", len(keys))
+	err := dedup.SetEXBatch(ctx, s.storage, keys, values, expirationOf(expiration))
+	end(span, err)
+	return err
+}
+
+func (s *TracingStorage) Scan(ctx context.Context, prefix []byte, fn func(key []byte) error) error {
+	ctx, span := s.start(ctx, "scan", 0)
+	err := dedup.Scan(ctx, s.storage, prefix, fn)
+	end(span, err)
+	return err
+}
+
+func (s *TracingStorage) Purge(ctx context.Context, prefix []byte) (int64, error) {
+	ctx, span := s.start(ctx, "purge", 0)
+	purged, err := dedup.Purge(ctx, s.storage, prefix)
+	end(span, err)
+	return purged, err
+}
+
+// expirationOf mirrors the Storage default of one hour when no expiration is given
+func expirationOf(expiration []time.Duration) time.Duration {
+	if len(expiration) > 0 {
+		return expiration[0]
+	}
+	return time.Hour
+}
@@ -0,0 +1,86 @@
+package oteldedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// failingStorage fails every Exists call
+type failingStorage struct {
+	dedup.Storage
+}
+
+func (failingStorage) Exists(context.Context, []byte) (bool, error) {
+	return false, assert.AnError
+}
+
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestTracing(t *testing.T) {
+	ctx := context.Background()
+	handler := func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }
+
+	setup := func(storage dedup.Storage) (*tracetest.SpanRecorder, *dedup.Deduper) {
+		recorder := tracetest.NewSpanRecorder()
+		tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("dedup")
+		deduper := dedup.New(handler, NewTracingStorage(storage, tracer), dedup.WithTracer(NewTracer(tracer)), dedup.WithPrefix("orders:"))
+		return recorder, deduper
+	}
+
+	t.Run("Operation spans parent storage spans", func(t *testing.T) {
+		recorder, deduper := setup(dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1}))
+
+		_, err := deduper.IsDuplicate(ctx, "order-1", dedup.DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		isDuplicate, err := deduper.IsDuplicate(ctx, "order-1", dedup.DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		spans := recorder.Ended()
+		assert.Len(t, spans, 4)
+
+		// MemoryStorage is atomic, so IsDuplicate claims with SetNX
+		claim, operation := spans[2], spans[3]
+		assert.Equal(t, "dedup.storage.set_nx", claim.Name())
+		assert.Equal(t, "set_nx", attributes(claim)["dedup.storage.call"].AsString())
+		assert.Equal(t, operation.SpanContext().SpanID(), claim.Parent().SpanID())
+
+		assert.Equal(t, "dedup.is_duplicate", operation.Name())
+		attrs := attributes(operation)
+		assert.Equal(t, "orders:", attrs["dedup.prefix"].AsString())
+		assert.Equal(t, "raw", attrs["dedup.key_mode"].AsString())
+		assert.Equal(t, "duplicate", attrs["dedup.decision"].AsString())
+		assert.Equal(t, codes.Unset, operation.Status().Code)
+	})
+
+	t.Run("Storage errors", func(t *testing.T) {
+		recorder, deduper := setup(failingStorage{Storage: dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})})
+
+		_, err := deduper.IsDuplicate(ctx, "order-1", dedup.DefaultHashStrategy())
+		assert.Error(t, err)
+
+		spans := recorder.Ended()
+		assert.Len(t, spans, 2)
+		for _, span := range spans {
+			assert.Equal(t, codes.Error, span.Status().Code)
+			assert.Len(t, span.Events(), 1, "the error is recorded")
+		}
+		attrs := attributes(spans[1])
+		assert.True(t, attrs["dedup.storage_error"].AsBool())
+		assert.NotContains(t, attrs, attribute.Key("dedup.decision"))
+	})
+}
@@ -0,0 +1,88 @@
+// Package promdedup provides a Prometheus adapter for dedup.Metrics
+package promdedup
+
+import (
+	"context"
+	"time"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/pixie-sh/errors-go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type config struct {
+	namespace string
+	buckets   []float64
+}
+
+// Option configures the Prometheus collectors
+type Option func(c *config)
+
+// WithNamespace sets the metric namespace, defaults to "dedup"
+func WithNamespace(namespace string) Option {
+	return func(c *config) {
+		c.namespace = namespace
+	}
+}
+
+// WithBuckets sets the storage latency histogram buckets in seconds, defaults to prometheus.DefBuckets
+func WithBuckets(buckets []float64) Option {
+	return func(c *config) {
+		c.buckets = buckets
+	}
+}
+
+// Metrics implements dedup.Metrics with a counter vector of events
+// and a histogram vector of storage call latencies
+type Metrics struct {
+	events  *prometheus.CounterVec
+	storage *prometheus.HistogramVec
+}
+
+var _ dedup.Metrics = (*Metrics)(nil)
+
+// NewMetrics creates the collectors and registers them on registerer:
+// <namespace>_events_total{prefix,operation,event} and
+// <namespace>_storage_duration_seconds{prefix,call,outcome}
+func NewMetrics(registerer prometheus.Registerer, opts ...Option) (*Metrics, error) {
+	cfg := config{namespace: "dedup", buckets: prometheus.DefBuckets}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	m := &Metrics{
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cfg.namespace,
+			Name:      "events_total",
+			Help:      "Deduper checks, duplicates, stores and errors by key prefix and operation.",
+		}, []string{"prefix", "operation", "event"}),
+		storage: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cfg.namespace,
+			Name:      "storage_duration_seconds",
+			Help:      "Latency of the storage calls made by a Deduper.",
+			Buckets:   cfg.buckets,
+		}, []string{"prefix", "call", "outcome"}),
+	}
+
+	for _, collector := range []prometheus.Collector{m.events, m.storage} {
+		err := registerer.Register(collector)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to register dedup metrics; %s", err.Error())
+		}
+	}
+	return m, nil
+}
+
+// Count adds n events
+func (m *Metrics) Count(_ context.Context, prefix string, operation string, event dedup.MetricEvent, n int) {
+	m.events.WithLabelValues(prefix, operation, string(event)).Add(float64(n))
+}
+
+// ObserveStorage records a storage call latency, with outcome "ok" or "error"
+func (m *Metrics) ObserveStorage(_ context.Context, prefix string, call string, duration time.Duration, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	m.storage.WithLabelValues(prefix, call, outcome).Observe(duration.Seconds())
+}
@@ -0,0 +1,41 @@
+package promdedup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	registry := prometheus.NewRegistry()
+
+	metrics, err := NewMetrics(registry, WithNamespace("test"))
+	assert.NoError(t, err)
+
+	handler := func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }
+	storage := dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})
+	deduper := dedup.New(handler, storage, dedup.WithMetrics(metrics), dedup.WithPrefix("orders:"))
+
+	for i := 0; i < 3; i++ {
+		_, err := deduper.IsDuplicate(ctx, "order-1", dedup.DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(metrics.events.WithLabelValues("orders:", dedup.OpIsDuplicate, "check")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.events.WithLabelValues("orders:", dedup.OpIsDuplicate, "duplicate")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.events.WithLabelValues("orders:", dedup.OpIsDuplicate, "store")))
+
+	count, err := testutil.GatherAndCount(registry, "test_storage_duration_seconds")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count, "one series for prefix orders:, call set_nx, outcome ok")
+
+	// Registering twice on the same registry fails
+	_, err = NewMetrics(registry, WithNamespace("test"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,189 @@
+package dedup
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// slotRecorder is a go-redis hook that records the cluster slot of every key sent,
+// turning a single miniredis node into a cluster-shaped fake
+type slotRecorder struct {
+	mu    sync.Mutex
+	slots map[int]struct{}
+}
+
+func (s *slotRecorder) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (s *slotRecorder) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		s.record(cmd)
+		return next(ctx, cmd)
+	}
+}
+
+func (s *slotRecorder) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			s.record(cmd)
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func (s *slotRecorder) record(cmd redis.Cmder) {
+	switch strings.ToLower(cmd.Name()) {
+	case "get", "set", "exists", "del", "pexpire", "ttl":
+	default:
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.slots[keySlot(cmd.Args()[1].(string))] = struct{}{}
+}
+
+func (s *slotRecorder) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.slots = map[int]struct{}{}
+}
+
+func (s *slotRecorder) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.slots)
+}
+
+// keySlot is the Redis Cluster slot of a key: CRC16 of the hash tag, or of the whole key, mod 16384
+func keySlot(key string) int {
+	if open := strings.IndexByte(key, '{'); open >= 0 {
+		if end := strings.IndexByte(key[open+1:], '}'); end > 0 {
+			key = key[open+1 : open+1+end]
+		}
+	}
+
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % 16384
+}
+
+func TestHashTag(t *testing.T) {
+	assert.Equal(t, "{dedup:orders}:", HashTag("dedup:orders:"))
+	assert.Equal(t, "{orders}", HashTag("orders"))
+	assert.Equal(t, "{dedup:orders}:", HashTag("{dedup:orders}:"))
+
+	// Empty braces are not a tag for Redis, so the prefix is still wrapped
+	assert.Equal(t, "{x:{}}:", HashTag("x:{}:"))
+
+	t.Run("Applied after WithPrefix", func(t *testing.T) {
+		handler := func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }
+		deduper := New(handler, NewMemoryStorage(context.Background()), WithHashTag(), WithPrefix("custom:"))
+		assert.Equal(t, []byte("{custom}:abc"), deduper.buildKey(context.Background(), []byte("abc")))
+	})
+}
+
+func TestRedisStorageUniversalClient(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	ctx := context.Background()
+	handler := func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }
+
+	clients := map[string]redis.UniversalClient{
+		"Client": redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		// miniredis answers CLUSTER SLOTS with itself owning every slot
+		"ClusterClient":    redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{mr.Addr()}}),
+		"UniversalOptions": redis.NewUniversalClient(&redis.UniversalOptions{Addrs: []string{mr.Addr()}}),
+	}
+
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			defer client.Close()
+			mr.FlushAll()
+
+			deduper := New(handler, NewRedisStorage(ctx, client), WithHashTag())
+
+			duplicate, err := deduper.IsDuplicate(ctx, "order-1", DefaultHashStrategy(), time.Minute)
+			assert.NoError(t, err)
+			assert.False(t, duplicate)
+
+			duplicate, err = deduper.IsDuplicate(ctx, "order-1", DefaultHashStrategy(), time.Minute)
+			assert.NoError(t, err)
+			assert.True(t, duplicate)
+
+			dups, errs := deduper.IsDuplicateBatch(ctx, []any{"order-1", "order-2"}, DefaultHashStrategy(), time.Minute)
+			assert.Equal(t, []bool{true, false}, dups)
+			assert.Equal(t, []error{nil, nil}, errs)
+
+			assert.True(t, mr.Exists("{dedup:string}:order-2"))
+		})
+	}
+}
+
+func TestRedisStorageClusterSlots(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs: []string{mr.Addr()},
+		// Route every node announced by CLUSTER SLOTS back to miniredis
+		Dialer: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, mr.Addr())
+		},
+	})
+	defer client.Close()
+
+	recorder := &slotRecorder{}
+	client.AddHook(recorder)
+
+	ctx := context.Background()
+	handler := func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }
+	entities := []any{"order-1", "order-2", "order-3", "order-4", "order-5", "order-6"}
+
+	t.Run("Without hash tag keys spread over slots", func(t *testing.T) {
+		mr.FlushAll()
+		recorder.reset()
+
+		deduper := New(handler, NewRedisStorage(ctx, client))
+		_, errs := deduper.IsDuplicateBatch(ctx, entities, DefaultHashStrategy(), time.Minute)
+		assert.Equal(t, make([]error, len(entities)), errs)
+		assert.Greater(t, recorder.count(), 1)
+	})
+
+	t.Run("With hash tag every key shares one slot", func(t *testing.T) {
+		mr.FlushAll()
+		recorder.reset()
+
+		deduper := New(handler, NewRedisStorage(ctx, client), WithHashTag())
+		_, errs := deduper.IsDuplicateBatch(ctx, entities, DefaultHashStrategy(), time.Minute)
+		assert.Equal(t, make([]error, len(entities)), errs)
+
+		_, err := deduper.StoreResult(ctx, "order-1", DefaultHashStrategy(), []byte("ok"), time.Minute)
+		assert.NoError(t, err)
+		_, _, err = deduper.Begin(ctx, "order-7", DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		assert.NoError(t, deduper.Forget(ctx, "order-2", DefaultHashStrategy()))
+
+		assert.Equal(t, 1, recorder.count())
+	})
+}
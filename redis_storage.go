@@ -2,6 +2,9 @@ package dedup
 
 import (
 	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -9,14 +12,59 @@ import (
 
 // RedisStorage implements the binary-safe Storage interface with Redis
 type RedisStorage struct {
-	client *redis.Client
+	client redis.UniversalClient
 }
 
-// NewRedisStorage creates a new RedisStorage instance
-func NewRedisStorage(_ context.Context, client *redis.Client) *RedisStorage {
+// NewRedisStorage creates a new RedisStorage instance.
+// Any redis.UniversalClient works: *redis.Client, *redis.ClusterClient, *redis.Ring
+// or a Sentinel failover client. In cluster mode pair it with WithHashTag so all
+// keys of a Deduper hash to the same slot.
+func NewRedisStorage(_ context.Context, client redis.UniversalClient) *RedisStorage {
 	return &RedisStorage{client: client}
 }
 
+// compareAndSwapScript returns {matched, replaced}; a missing key is a mismatch.
+// ARGV: value, replace flag, expiration in milliseconds (0 for none)
+var compareAndSwapScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if current == ARGV[1] then
+	return {1, 0}
+end
+if ARGV[2] ~= '1' then
+	return {0, 0}
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return {0, 1}
+`)
+
+// incrScript returns {count, ttl in milliseconds}; the expiration is only set on a counter without one.
+// ARGV: expiration in milliseconds (0 for none)
+var incrScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl == -1 and tonumber(ARGV[1]) > 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`)
+
+// HashTag wraps a key prefix in a Redis Cluster hash tag, "dedup:orders:" becomes "{dedup:orders}:".
+// Only the tag is hashed by the cluster, so every key sharing the prefix lands in the same slot.
+// Prefixes that already contain a tag are returned unchanged.
+func HashTag(prefix string) string {
+	if open := strings.IndexByte(prefix, '{'); open >= 0 && strings.IndexByte(prefix[open:], '}') > 1 {
+		return prefix
+	}
+
+	tag := strings.TrimSuffix(prefix, ":")
+	return "{" + tag + "}" + prefix[len(tag):]
+}
+
 // Get retrieves a binary-safe value by key
 func (r *RedisStorage) Get(ctx context.Context, key []byte) ([]byte, error) {
 	cmd := r.client.Get(ctx, string(key))
@@ -38,6 +86,15 @@ func (r *RedisStorage) Exists(ctx context.Context, key []byte) (bool, error) {
 	return val > 0, nil
 }
 
+// Del removes the given binary key and reports whether it existed
+func (r *RedisStorage) Del(ctx context.Context, key []byte) (bool, error) {
+	val, err := r.client.Del(ctx, string(key)).Result()
+	if err != nil {
+		return false, err
+	}
+	return val > 0, nil
+}
+
 // SetEX stores a binary-safe value with a key and expiration time in Redis
 func (r *RedisStorage) SetEX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error {
 	exp := time.Hour // default
@@ -47,7 +104,175 @@ func (r *RedisStorage) SetEX(ctx context.Context, key []byte, value []byte, expi
 	return r.client.Set(ctx, string(key), value, exp).Err()
 }
 
+// SetNX stores a binary-safe value only if the key does not exist yet, using SET NX PX
+func (r *RedisStorage) SetNX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) (bool, error) {
+	exp := time.Hour // default
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+	return r.client.SetNX(ctx, string(key), value, exp).Result()
+}
+
+// Expire refreshes the expiration of a binary key using PEXPIRE
+func (r *RedisStorage) Expire(ctx context.Context, key []byte, expiration time.Duration) (bool, error) {
+	return r.client.PExpire(ctx, string(key), expiration).Result()
+}
+
+// CompareAndSwap compares the stored value with value and replaces it on mismatch when replace is set,
+// all in one Lua script so concurrent writers of different values cannot interleave
+func (r *RedisStorage) CompareAndSwap(ctx context.Context, key []byte, value []byte, replace bool, expiration ...time.Duration) (bool, bool, error) {
+	exp := time.Hour // default
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+
+	flag := "0"
+	if replace {
+		flag = "1"
+	}
+
+	res, err := compareAndSwapScript.Run(ctx, r.client, []string{string(key)}, value, flag, exp.Milliseconds()).Int64Slice()
+	if err != nil {
+		return false, false, err
+	}
+	return res[0] == 1, res[1] == 1, nil
+}
+
+// Incr increments the counter under key with INCR and starts its window with PEXPIRE,
+// in one Lua script so a crash between the two cannot leave a counter that never expires
+func (r *RedisStorage) Incr(ctx context.Context, key []byte, expiration time.Duration) (int64, time.Duration, error) {
+	res, err := incrScript.Run(ctx, r.client, []string{string(key)}, expiration.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if res[1] < 0 {
+		return res[0], -1, nil
+	}
+	return res[0], time.Duration(res[1]) * time.Millisecond, nil
+}
+
 // TTL retrieves the remaining time-to-live for a given binary key
 func (r *RedisStorage) TTL(ctx context.Context, key []byte) (time.Duration, error) {
 	return r.client.TTL(ctx, string(key)).Result()
 }
+
+// ExistsBatch checks many binary keys with pipelined EXISTS calls
+func (r *RedisStorage) ExistsBatch(ctx context.Context, keys [][]byte) ([]bool, error) {
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			cmds[i] = pipe.Exists(ctx, string(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists := make([]bool, len(keys))
+	for i, cmd := range cmds {
+		exists[i] = cmd.Val() > 0
+	}
+	return exists, nil
+}
+
+// SetEXBatch stores many binary-safe values with pipelined SET calls
+func (r *RedisStorage) SetEXBatch(ctx context.Context, keys [][]byte, values [][]byte, expiration ...time.Duration) error {
+	exp := time.Hour // default
+	if len(expiration) > 0 {
+		exp = expiration[0]
+	}
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			pipe.Set(ctx, string(key), values[i], exp)
+		}
+		return nil
+	})
+	return err
+}
+
+// scanCount is the COUNT hint of every SCAN call
+const scanCount = 1000
+
+// Scan calls fn with every key starting with prefix using SCAN MATCH, on every master or shard of
+// cluster and ring clients. Calls to fn are serialized even though those are scanned concurrently.
+func (r *RedisStorage) Scan(ctx context.Context, prefix []byte, fn func(key []byte) error) error {
+	var mu sync.Mutex
+	return r.scan(ctx, prefix, func(_ context.Context, _ redis.Cmdable, keys []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, key := range keys {
+			err := fn([]byte(key))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Purge deletes every key starting with prefix, one pipelined UNLINK per key of each SCAN page,
+// and returns how many were deleted
+func (r *RedisStorage) Purge(ctx context.Context, prefix []byte) (int64, error) {
+	var purged atomic.Int64
+	err := r.scan(ctx, prefix, func(ctx context.Context, node redis.Cmdable, keys []string) error {
+		cmds := make([]*redis.IntCmd, len(keys))
+		_, err := node.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range keys {
+				cmds[i] = pipe.Unlink(ctx, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, cmd := range cmds {
+			purged.Add(cmd.Val())
+		}
+		return nil
+	})
+	return purged.Load(), err
+}
+
+// scan calls page with each non-empty SCAN page and the node it came from
+func (r *RedisStorage) scan(ctx context.Context, prefix []byte, page func(ctx context.Context, node redis.Cmdable, keys []string) error) error {
+	match := globEscaper.Replace(string(prefix)) + "*"
+
+	scanNode := func(ctx context.Context, node redis.Cmdable) error {
+		var cursor uint64
+		for {
+			keys, next, err := node.Scan(ctx, cursor, match, scanCount).Result()
+			if err != nil {
+				return err
+			}
+			if len(keys) > 0 {
+				err = page(ctx, node, keys)
+				if err != nil {
+					return err
+				}
+			}
+			if next == 0 {
+				return nil
+			}
+			cursor = next
+		}
+	}
+
+	if cluster, ok := r.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scanNode(ctx, node)
+		})
+	}
+	if ring, ok := r.client.(*redis.Ring); ok {
+		return ring.ForEachShard(ctx, func(ctx context.Context, node *redis.Client) error {
+			return scanNode(ctx, node)
+		})
+	}
+	return scanNode(ctx, r.client)
+}
+
+// globEscaper escapes the glob metacharacters of SCAN MATCH patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
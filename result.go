@@ -0,0 +1,52 @@
+package dedup
+
+import (
+	"context"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+)
+
+// StoreResult keeps a result payload next to the entity key, so duplicates can replay the original response.
+// The key follows the strategy key hashing rules; the payload is stored as is. Forget and ForgetHash delete it.
+func (d *Deduper) StoreResult(ctx context.Context, entity any, strategy HashStrategy, result []byte, expiration time.Duration) ([]byte, error) {
+	dedupHash, err := d.Hash(ctx, entity, strategy, false)
+	if err != nil {
+		return nil, err
+	}
+	return d.StoreResultHash(ctx, dedupHash, result, expiration)
+}
+
+// GetResult returns the result payload stored for the entity and whether one was found
+func (d *Deduper) GetResult(ctx context.Context, entity any, strategy HashStrategy) ([]byte, bool, error) {
+	dedupHash, err := d.Hash(ctx, entity, strategy, false)
+	if err != nil {
+		return nil, false, err
+	}
+	return d.GetResultHash(ctx, dedupHash)
+}
+
+// StoreResultHash is StoreResult for a precomputed hash, as used by StoreHash
+func (d *Deduper) StoreResultHash(ctx context.Context, hash []byte, result []byte, expiration time.Duration) ([]byte, error) {
+	key := d.taggedKey(ctx, resultTag, hash)
+	err := d.storage.SetEX(ctx, key, result, expiration)
+	if err != nil {
+		d.count(ctx, OpResult, MetricStorageError, 1)
+		return nil, errors.Wrap(err, "failed to store result; %s", err.Error(), DedupStorageErrorCode)
+	}
+	return key, nil
+}
+
+// GetResultHash is GetResult for a precomputed hash
+func (d *Deduper) GetResultHash(ctx context.Context, hash []byte) ([]byte, bool, error) {
+	key := d.taggedKey(ctx, resultTag, hash)
+	result, err := d.storage.Get(ctx, key)
+	if err != nil {
+		d.count(ctx, OpResult, MetricStorageError, 1)
+		return nil, false, errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
+	}
+	if result == nil {
+		return nil, false, nil
+	}
+	return result, true, nil
+}
@@ -0,0 +1,154 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduperResults(t *testing.T) {
+	// Setup miniredis
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	storage := NewRedisStorage(ctx, client)
+
+	hashHandler := func(ctx context.Context, key string) ([]byte, error) {
+		return []byte(key), nil
+	}
+
+	t.Run("StoreResult and GetResult", func(t *testing.T) {
+		mr.FlushAll()
+
+		deduper := New(hashHandler, storage, WithPrefix("results:"))
+
+		result, found, err := deduper.GetResult(ctx, "req-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, found)
+		assert.Nil(t, result)
+
+		key, err := deduper.StoreResult(ctx, "req-1", DefaultHashStrategy(), []byte("HTTP/1.1 201 Created"), 10*time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, "results:\x00r:req-1", string(key))
+		assert.True(t, mr.TTL(string(key)) > 0)
+
+		result, found, err = deduper.GetResult(ctx, "req-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte("HTTP/1.1 201 Created"), result)
+
+		// The result does not mark the entity itself as seen
+		isDuplicate, err := deduper.IsDuplicate(ctx, "req-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		mr.FastForward(11 * time.Second)
+		_, found, err = deduper.GetResult(ctx, "req-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("Result key honors the key hashing strategy", func(t *testing.T) {
+		mr.FlushAll()
+
+		deduper := New(hashHandler, storage, WithPrefix("results:"))
+		longKey := strings.Repeat("k", 64)
+
+		key, err := deduper.StoreResult(ctx, longKey, DefaultHashStrategy(), []byte("payload"), 10*time.Second)
+		assert.NoError(t, err)
+
+		sum := sha256.Sum256([]byte(longKey))
+		assert.Equal(t, "results:\x00r:"+string(sum[:]), string(key))
+
+		// Large payloads are stored verbatim so they can be replayed
+		payload := []byte(strings.Repeat("p", 1024))
+		_, err = deduper.StoreResult(ctx, "req-2", HashStrategy{ValueHashMode: AlwaysHash}, payload, 10*time.Second)
+		assert.NoError(t, err)
+
+		result, found, err := deduper.GetResult(ctx, "req-2", HashStrategy{ValueHashMode: AlwaysHash})
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, payload, result)
+	})
+
+	t.Run("Result keys never collide with entity keys", func(t *testing.T) {
+		mr.FlushAll()
+
+		deduper := New(hashHandler, storage, WithPrefix("results:"))
+
+		_, err := deduper.StoreResult(ctx, "x", DefaultHashStrategy(), []byte("payload"), 10*time.Second)
+		assert.NoError(t, err)
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, "x:result", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+
+	t.Run("Forget deletes the result", func(t *testing.T) {
+		mr.FlushAll()
+
+		deduper := New(hashHandler, storage, WithPrefix("results:"))
+
+		_, _, err := deduper.Store(ctx, "req-1", DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		_, err = deduper.StoreResult(ctx, "req-1", DefaultHashStrategy(), []byte("failed attempt"), 10*time.Second)
+		assert.NoError(t, err)
+
+		err = deduper.Forget(ctx, "req-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+
+		_, found, err := deduper.GetResult(ctx, "req-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, found)
+		assert.Empty(t, mr.Keys())
+	})
+
+	t.Run("Hash variants work with StoreHash", func(t *testing.T) {
+		mr.FlushAll()
+
+		deduper := New(hashHandler, storage)
+
+		_, err := deduper.StoreHash(ctx, []byte("hash"), 10*time.Second)
+		assert.NoError(t, err)
+		_, err = deduper.StoreResultHash(ctx, []byte("hash"), []byte("payload"), 10*time.Second)
+		assert.NoError(t, err)
+
+		result, found, err := deduper.GetResultHash(ctx, []byte("hash"))
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, []byte("payload"), result)
+	})
+
+	t.Run("Storage errors", func(t *testing.T) {
+		mockStorage := &MockStorage{
+			getFunc: func(ctx context.Context, key []byte) ([]byte, error) {
+				return nil, assert.AnError
+			},
+			setExFunc: func(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error {
+				return assert.AnError
+			},
+		}
+		deduper := New(hashHandler, mockStorage)
+
+		_, err := deduper.StoreResult(ctx, "req-1", DefaultHashStrategy(), []byte("payload"), time.Second)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to store result")
+
+		_, _, err = deduper.GetResult(ctx, "req-1", DefaultHashStrategy())
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "storage error")
+	})
+}
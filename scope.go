@@ -0,0 +1,124 @@
+package dedup
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+)
+
+type scopeKey struct{}
+
+// scopeEscaper keeps the scope segment of a key unambiguous, a scope never contains a raw ':'
+var scopeEscaper = strings.NewReplacer("%", "%25", ":", "%3A")
+
+// ScopeDefaults overrides the Deduper defaults for one scope, see WithScopeDefaults
+type ScopeDefaults struct {
+	// Strategy replaces the default strategy when set
+	Strategy *HashStrategy
+	// TTL replaces the default TTL when positive
+	TTL time.Duration
+}
+
+// ContextWithScope returns a ctx scoping the Deduper calls made with it to scope, a tenant ID
+// or any other dynamic dimension. Scoped keys are "<prefix>\x00s:<scope>:<hash>", so every scope
+// has its own namespace under the Deduper prefix, apart from the unscoped keys.
+func ContextWithScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// ScopeFromContext returns the scope set by ContextWithScope, "" when there is none
+func ScopeFromContext(ctx context.Context) string {
+	scope, _ := ctx.Value(scopeKey{}).(string)
+	return scope
+}
+
+// Scoped returns a Deduper sharing the storage and options of d with every call scoped to scope,
+// whatever the ctx carries. Its DefaultStrategy and DefaultTTL are those of scope.
+func (d *Deduper) Scoped(scope string) *Deduper {
+	scoped := *d
+	scoped.scope = scope
+	scoped.strategy, scoped.ttl = d.defaultsOf(scope)
+	return &scoped
+}
+
+// scopeOf returns the scope of a call, the one of Scoped before the one of ctx
+func (d *Deduper) scopeOf(ctx context.Context) string {
+	if d.scope != "" {
+		return d.scope
+	}
+	return ScopeFromContext(ctx)
+}
+
+// keyPrefix returns the prefix of the keys of a call, the Deduper prefix followed by its scope
+func (d *Deduper) keyPrefix(ctx context.Context) []byte {
+	scope := d.scopeOf(ctx)
+	if scope == "" {
+		return d.prefix
+	}
+	return scopePrefix(d.prefix, scope)
+}
+
+// scopePrefix appends the tagged and escaped scope segment to prefix
+func scopePrefix(prefix []byte, scope string) []byte {
+	escaped := scopeEscaper.Replace(scope)
+	key := make([]byte, 0, len(prefix)+len(scopeTag)+len(escaped)+1)
+	key = append(key, prefix...)
+	key = append(key, scopeTag...)
+	key = append(key, escaped...)
+	return append(key, ':')
+}
+
+// defaults returns the default strategy and TTL of the scope of a call
+func (d *Deduper) defaults(ctx context.Context) (HashStrategy, time.Duration) {
+	if d.scope != "" {
+		return d.strategy, d.ttl
+	}
+	return d.defaultsOf(ScopeFromContext(ctx))
+}
+
+func (d *Deduper) defaultsOf(scope string) (HashStrategy, time.Duration) {
+	strategy, ttl := d.strategy, d.ttl
+
+	defaults, ok := d.scopes[scope]
+	if !ok {
+		return strategy, ttl
+	}
+	if defaults.Strategy != nil {
+		strategy = *defaults.Strategy
+	}
+	if defaults.TTL > 0 {
+		ttl = defaults.TTL
+	}
+	return strategy, ttl
+}
+
+// ScopeKeys calls fn with every key of scope, counters, results and fingerprints included.
+// The storage must implement ScanStorage.
+func (d *Deduper) ScopeKeys(ctx context.Context, scope string, fn func(key []byte) error) error {
+	if scope == "" {
+		return errors.New("scope is empty", DedupInvalidScopeErrorCode)
+	}
+
+	err := Scan(ctx, d.storage, scopePrefix(d.prefix, scope), fn)
+	if err != nil {
+		return errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
+	}
+	return nil
+}
+
+// PurgeScope deletes every key of scope and returns how many were deleted.
+// The storage must implement PurgeStorage or ScanStorage.
+func (d *Deduper) PurgeScope(ctx context.Context, scope string) (int, error) {
+	if scope == "" {
+		return 0, errors.New("scope is empty", DedupInvalidScopeErrorCode)
+	}
+
+	purged, err := Purge(ctx, d.storage, scopePrefix(d.prefix, scope))
+	if err != nil {
+		d.count(ctx, OpPurge, MetricStorageError, 1)
+		return int(purged), errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
+	}
+	return int(purged), nil
+}
@@ -0,0 +1,175 @@
+package dedup
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScope(t *testing.T) {
+	ctx := context.Background()
+	byID := func(_ context.Context, e TestEntity) ([]byte, error) { return []byte(e.ID), nil }
+	entity := TestEntity{ID: "order-1", Name: "Order"}
+	strategy := DefaultHashStrategy()
+
+	t.Run("Keys per scope", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		d := New(byID, storage, WithPrefix("orders:"))
+
+		_, key, err := d.Store(ContextWithScope(ctx, "acme"), entity, strategy, time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, "orders:\x00s:acme:order-1", string(key))
+
+		// colons in scopes are escaped, so "a:b" never shares keys with scope "a"
+		_, key, err = d.Scoped("a:b").Store(ctx, entity, strategy, time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, "orders:\x00s:a%3Ab:order-1", string(key))
+
+		// the explicit scope wins over the one of ctx
+		_, key, err = d.Scoped("globex").Store(ContextWithScope(ctx, "acme"), entity, strategy, time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, "orders:\x00s:globex:order-1", string(key))
+
+		key, err = d.Key(ContextWithScope(ctx, "acme"), entity, strategy)
+		assert.NoError(t, err)
+		assert.Equal(t, "orders:\x00s:acme:order-1", string(key))
+
+		isDuplicate, err := d.IsDuplicate(ContextWithScope(ctx, "acme"), entity, strategy)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		isDuplicate, err = d.IsDuplicate(ContextWithScope(ctx, "initech"), entity, strategy)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		isDuplicate, err = d.IsDuplicate(ctx, entity, strategy)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		occurrences, err := d.IsDuplicateN(ContextWithScope(ctx, "acme"), entity, strategy, 1, time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), occurrences.Count)
+		exists, err := storage.Exists(ctx, []byte("orders:\x00s:acme:\x00n:order-1"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("Scoped keys never collide with unscoped ones", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		d := New(byID, storage, WithPrefix("orders:"))
+
+		_, _, err := d.Store(ContextWithScope(ctx, "acme"), entity, strategy, time.Minute)
+		assert.NoError(t, err)
+
+		isDuplicate, err := d.IsDuplicate(ctx, TestEntity{ID: "acme:order-1"}, strategy)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		_, _, err = d.Store(ctx, TestEntity{ID: "globex:order-1"}, strategy, time.Minute)
+		assert.NoError(t, err)
+		isDuplicate, err = d.IsDuplicate(ContextWithScope(ctx, "globex"), entity, strategy)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+
+	t.Run("Scope defaults", func(t *testing.T) {
+		clock := newFakeClock()
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now})
+		hashed := HashStrategy{KeyHashMode: AlwaysHash, ValueHashMode: AlwaysHash}
+		d := New(byID, storage,
+			WithPrefix("orders:"),
+			WithDefaultTTL(time.Minute),
+			WithScopeDefaults("acme", ScopeDefaults{Strategy: &hashed, TTL: time.Hour}),
+			WithScopeDefaults("globex", ScopeDefaults{TTL: 2 * time.Hour}),
+		)
+
+		assert.Equal(t, hashed, d.Scoped("acme").DefaultStrategy())
+		assert.Equal(t, time.Hour, d.Scoped("acme").DefaultTTL())
+		assert.Equal(t, DefaultHashStrategy(), d.Scoped("globex").DefaultStrategy())
+		assert.Equal(t, 2*time.Hour, d.Scoped("globex").DefaultTTL())
+		assert.Equal(t, time.Minute, d.Scoped("initech").DefaultTTL())
+
+		acme := ContextWithScope(ctx, "acme")
+		_, err := d.Seen(acme, entity)
+		assert.NoError(t, err)
+
+		hash, err := d.Hash(acme, entity, hashed, false)
+		assert.NoError(t, err)
+		ttl, err := d.TTL(acme, hash)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Hour, ttl)
+	})
+
+	t.Run("Purge a scope", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		storage := NewRedisStorage(ctx, redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+		d := New(byID, storage, WithPrefix("orders*:"))
+
+		for _, id := range []string{"order-1", "order-2"} {
+			_, err := d.Seen(ContextWithScope(ctx, "acme"), TestEntity{ID: id})
+			assert.NoError(t, err)
+			_, err = d.Seen(ContextWithScope(ctx, "acme-2"), TestEntity{ID: id})
+			assert.NoError(t, err)
+		}
+		_, err := d.Seen(ctx, entity)
+		assert.NoError(t, err)
+		// an unscoped key that looks like a key of scope acme
+		_, err = d.Seen(ctx, TestEntity{ID: "acme:order-3"})
+		assert.NoError(t, err)
+
+		var keys []string
+		err = d.ScopeKeys(ctx, "acme", func(key []byte) error {
+			keys = append(keys, string(key))
+			return nil
+		})
+		assert.NoError(t, err)
+		sort.Strings(keys)
+		assert.Equal(t, []string{"orders*:\x00s:acme:order-1", "orders*:\x00s:acme:order-2"}, keys)
+
+		purged, err := d.PurgeScope(ctx, "acme")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, purged)
+
+		assert.ElementsMatch(t, []string{"orders*:\x00s:acme-2:order-1", "orders*:\x00s:acme-2:order-2", "orders*:order-1", "orders*:acme:order-3"}, mr.Keys())
+
+		deleted, err := storage.Purge(ctx, []byte("orders*:\x00s:acme-2:"))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), deleted)
+		assert.ElementsMatch(t, []string{"orders*:order-1", "orders*:acme:order-3"}, mr.Keys())
+
+		_, err = d.PurgeScope(ctx, "")
+		assert.True(t, hasCode(err, DedupInvalidScopeErrorCode))
+	})
+
+	t.Run("Purge falls back to Scan and Del", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		redisStorage := NewRedisStorage(ctx, redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+		// Scan and Del only, the pipelined Purge is hidden
+		storage := struct {
+			Storage
+			ScanStorage
+		}{redisStorage, redisStorage}
+		d := New(byID, storage, WithPrefix("orders:"))
+
+		_, err := d.Seen(ContextWithScope(ctx, "acme"), entity)
+		assert.NoError(t, err)
+		_, err = d.Seen(ctx, entity)
+		assert.NoError(t, err)
+
+		purged, err := d.PurgeScope(ctx, "acme")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, purged)
+		assert.Equal(t, []string{"orders:order-1"}, mr.Keys())
+	})
+
+	t.Run("Storage without Scan", func(t *testing.T) {
+		d := New(byID, &MockStorage{})
+		_, err := d.PurgeScope(ctx, "acme")
+		assert.True(t, hasCode(err, DedupUnsupportedStorageErrorCode))
+	})
+}
@@ -0,0 +1,140 @@
+// Package serialize provides ready-made dedup serializers, to be passed to dedup.WithSerializer.
+// All of them return []byte, so values are stored without a string copy.
+package serialize
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"math"
+	"math/big"
+	"strings"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/pixie-sh/errors-go"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	_ dedup.Serializer = JSON
+	_ dedup.Serializer = CanonicalJSON
+	_ dedup.Serializer = Gob
+	_ dedup.Serializer = MsgPack
+	_ dedup.Serializer = Proto
+)
+
+// JSON serializes with encoding/json, the dedup default
+func JSON(_ context.Context, entity any) ([]byte, error) {
+	return json.Marshal(entity)
+}
+
+// CanonicalJSON serializes to JSON with object keys sorted, no insignificant whitespace,
+// no HTML escaping and numbers in their shortest form, so semantically equal values
+// serialize identically: {"b":1.0, "a":2} and {"a":2,"b":1} both become {"a":2,"b":1}.
+// Integers are kept exact whatever their size; numbers with a fraction or an exponent are
+// compared as float64, and those holding an integer within int64 equal that integer.
+func CanonicalJSON(_ context.Context, entity any) ([]byte, error) {
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var value any
+	err = decoder.Decode(&value)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode JSON; %s", err.Error())
+	}
+
+	value, err = canonicalNumbers(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// maps are encoded with sorted keys
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// canonicalNumbers rewrites every json.Number in its shortest form
+func canonicalNumbers(value any) (any, error) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			canonical, err := canonicalNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = canonical
+		}
+	case []any:
+		for i, item := range v {
+			canonical, err := canonicalNumbers(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = canonical
+		}
+	case json.Number:
+		// integer text is kept exact, float64 would merge integers above 2^53
+		if !strings.ContainsAny(v.String(), ".eE") {
+			n, ok := new(big.Int).SetString(v.String(), 10)
+			if !ok {
+				return nil, errors.New("number %s is not an integer", v.String())
+			}
+			return json.Number(n.String()), nil
+		}
+
+		f, err := v.Float64()
+		if err != nil || math.IsInf(f, 0) {
+			return nil, errors.New("number %s is out of range", v.String())
+		}
+		if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return int64(f), nil
+		}
+		return f, nil
+	}
+	return value, nil
+}
+
+// Gob serializes with encoding/gob. Gob writes maps in random order,
+// so entities holding maps do not serialize identically twice; prefer MsgPack for those.
+func Gob(_ context.Context, entity any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(entity)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MsgPack serializes with MessagePack, map keys sorted so equal maps serialize identically
+func MsgPack(_ context.Context, entity any) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := msgpack.NewEncoder(&buf)
+	encoder.SetSortMapKeys(true)
+	err := encoder.Encode(entity)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Proto serializes proto.Message entities with deterministic proto.Marshal
+func Proto(_ context.Context, entity any) ([]byte, error) {
+	message, ok := entity.(proto.Message)
+	if !ok {
+		return nil, errors.New("entity is not a proto message", dedup.DedupEntityTypeMismatchErrorCode)
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(message)
+}
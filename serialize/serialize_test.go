@@ -0,0 +1,111 @@
+package serialize
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	dedup "github.com/pixie-sh/dedup-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type Order struct {
+	ID    string            `json:"id" msgpack:"id"`
+	Total float64           `json:"total" msgpack:"total"`
+	Tags  map[string]string `json:"tags" msgpack:"tags"`
+}
+
+func TestSerializers(t *testing.T) {
+	ctx := context.Background()
+	order := Order{ID: "order-1", Total: 12.5, Tags: map[string]string{"b": "2", "a": "1"}}
+
+	t.Run("JSON", func(t *testing.T) {
+		data, err := JSON(ctx, order)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"id":"order-1","total":12.5,"tags":{"a":"1","b":"2"}}`, string(data))
+	})
+
+	t.Run("Canonical JSON", func(t *testing.T) {
+		data, err := CanonicalJSON(ctx, order)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"id":"order-1","tags":{"a":"1","b":"2"},"total":12.5}`, string(data))
+
+		// Key order, whitespace and number spelling do not matter
+		a, err := CanonicalJSON(ctx, json.RawMessage(`{ "b": [1.0, 2e2, "<x>"], "a": {"d": 0.50, "c": null} }`))
+		assert.NoError(t, err)
+		b, err := CanonicalJSON(ctx, map[string]any{"a": map[string]any{"c": nil, "d": 0.5}, "b": []any{1, 200, "<x>"}})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"a":{"c":null,"d":0.5},"b":[1,200,"<x>"]}`, string(a))
+		assert.Equal(t, a, b)
+
+		// Integers above 2^53 are kept exact
+		largest, err := CanonicalJSON(ctx, map[string]uint64{"n": 18446744073709551615})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"n":18446744073709551615}`, string(largest))
+		near, err := CanonicalJSON(ctx, map[string]uint64{"n": 18446744073709551000})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"n":18446744073709551000}`, string(near))
+		huge, err := CanonicalJSON(ctx, json.RawMessage(`[-9007199254740993, 9007199254740993, 123456789012345678901234567890]`))
+		assert.NoError(t, err)
+		assert.Equal(t, `[-9007199254740993,9007199254740993,123456789012345678901234567890]`, string(huge))
+
+		_, err = CanonicalJSON(ctx, make(chan int))
+		assert.Error(t, err)
+	})
+
+	t.Run("Gob", func(t *testing.T) {
+		data, err := Gob(ctx, Order{ID: "order-1", Total: 12.5})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, data)
+
+		again, err := Gob(ctx, Order{ID: "order-1", Total: 12.5})
+		assert.NoError(t, err)
+		assert.Equal(t, data, again)
+	})
+
+	t.Run("MsgPack", func(t *testing.T) {
+		data, err := MsgPack(ctx, order)
+		assert.NoError(t, err)
+
+		var decoded Order
+		assert.NoError(t, msgpack.Unmarshal(data, &decoded))
+		assert.Equal(t, order, decoded)
+
+		// Map keys are sorted, equal maps serialize identically
+		for i := 0; i < 10; i++ {
+			again, err := MsgPack(ctx, Order{ID: "order-1", Total: 12.5, Tags: map[string]string{"a": "1", "b": "2"}})
+			assert.NoError(t, err)
+			assert.Equal(t, data, again)
+		}
+	})
+
+	t.Run("Proto", func(t *testing.T) {
+		data, err := Proto(ctx, wrapperspb.String("order-1"))
+		assert.NoError(t, err)
+
+		var decoded wrapperspb.StringValue
+		assert.NoError(t, proto.Unmarshal(data, &decoded))
+		assert.Equal(t, "order-1", decoded.GetValue())
+
+		_, err = Proto(ctx, order)
+		assert.Error(t, err)
+	})
+
+	t.Run("Deduper with canonical JSON", func(t *testing.T) {
+		handler := func(_ context.Context, raw json.RawMessage) ([]byte, error) { return []byte("order-1"), nil }
+		storage := dedup.NewMemoryStorage(ctx, dedup.MemoryStorageConfig{SweepInterval: -1})
+		deduper := dedup.New(handler, storage, dedup.WithSerializer(CanonicalJSON))
+
+		strategy := dedup.HashStrategy{KeyHashMode: dedup.NeverHash, ValueHashMode: dedup.NeverHash}
+		_, _, err := deduper.Store(ctx, json.RawMessage(`{"id":"order-1","total":12.5}`), strategy, time.Minute)
+		assert.NoError(t, err)
+
+		isDuplicate, err := deduper.IsValueDuplicate(ctx, json.RawMessage(`{"total": 12.50, "id": "order-1"}`), strategy)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+	})
+}
@@ -0,0 +1,380 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+)
+
+// SimilarityMethod is the signature used for near-duplicate detection
+type SimilarityMethod int
+
+const (
+	// SimHashMethod compares 64-bit SimHash signatures by Hamming distance
+	SimHashMethod SimilarityMethod = iota
+	// MinHashMethod compares MinHash signatures by estimated Jaccard similarity
+	MinHashMethod
+)
+
+// SimilarityConfig configures near-duplicate detection, see WithSimilarity
+type SimilarityConfig struct {
+	// Method picks the signature, SimHashMethod by default
+	Method SimilarityMethod
+	// Features extracts the features of an entity; defaults to the words of the serialized entity
+	// for SimHash and to its word 3-shingles for MinHash, see Shingles
+	Features func(ctx context.Context, entity any) ([][]byte, error)
+	// MaxDistance is the largest SimHash Hamming distance of a near-duplicate, defaults to 3
+	MaxDistance int
+	// MinJaccard is the smallest estimated MinHash Jaccard similarity of a near-duplicate, defaults to 0.8
+	MinJaccard float64
+	// Bands is the number of LSH buckets an item is indexed in. For SimHash it defaults to
+	// MaxDistance+1, which finds every signature within MaxDistance; for MinHash to 16.
+	Bands int
+	// Rows is the number of MinHash values per band, defaults to 4; the signature has Bands*Rows values
+	Rows int
+	// BucketSize caps the items remembered by an LSH bucket, the oldest are dropped first; defaults to 64
+	BucketSize int
+}
+
+// NearDuplicate is a stored item similar to a checked entity
+type NearDuplicate struct {
+	// Hash is the dedup hash of the stored item
+	Hash []byte
+	// Key is the key of the stored item, as built by Store
+	Key []byte
+	// Similarity is 1 - distance/64 for SimHash and the estimated Jaccard similarity for MinHash
+	Similarity float64
+}
+
+// similarityConfig returns the configured SimilarityConfig with defaults applied
+func (d *Deduper) similarityConfig() SimilarityConfig {
+	cfg := d.similarity
+	if cfg.Features == nil {
+		// SimHash distances grow quickly with shingle size on short texts, words keep near-duplicates within a few bits
+		k := 1
+		if cfg.Method == MinHashMethod {
+			k = 3
+		}
+		cfg.Features = func(ctx context.Context, entity any) ([][]byte, error) {
+			text, err := d.serializer(ctx, entity)
+			if err != nil {
+				return nil, err
+			}
+			return Shingles(string(text), k), nil
+		}
+	}
+	if cfg.MaxDistance <= 0 {
+		cfg.MaxDistance = 3
+	}
+	if cfg.MinJaccard <= 0 {
+		cfg.MinJaccard = 0.8
+	}
+	if cfg.Rows <= 0 {
+		cfg.Rows = 4
+	}
+	if cfg.BucketSize <= 0 {
+		cfg.BucketSize = 64
+	}
+	switch {
+	case cfg.Method == SimHashMethod && cfg.Bands <= 0:
+		cfg.Bands = min(cfg.MaxDistance+1, 64)
+	case cfg.Method == SimHashMethod:
+		cfg.Bands = min(cfg.Bands, 64)
+	case cfg.Bands <= 0:
+		cfg.Bands = 16
+	}
+	return cfg
+}
+
+// IsNearDuplicate reports whether a stored item is similar to the entity and returns the most
+// similar one. With storeIfNot, the fingerprint of an entity without near-duplicates is stored.
+func (d *Deduper) IsNearDuplicate(ctx context.Context, entity any, strategy HashStrategy, storeIfNot ...time.Duration) (NearDuplicate, bool, error) {
+	ctx, span := d.startSpan(ctx, OpIsNearDuplicate)
+	match, isDuplicate, err := d.isNearDuplicate(ctx, entity, strategy, storeIfNot...)
+	if isDuplicate {
+		span.set("dedup.similarity", match.Similarity)
+	}
+	span.decide(isDuplicate, err)
+	return match, isDuplicate, err
+}
+
+func (d *Deduper) isNearDuplicate(ctx context.Context, entity any, strategy HashStrategy, storeIfNot ...time.Duration) (NearDuplicate, bool, error) {
+	d.count(ctx, OpIsNearDuplicate, MetricCheck, 1)
+	cfg := d.similarityConfig()
+
+	dedupHash, signature, matches, err := d.findSimilar(ctx, cfg, entity, strategy)
+	if err != nil {
+		d.fail(ctx, OpIsNearDuplicate, err)
+		return NearDuplicate{}, false, err
+	}
+	if len(matches) > 0 {
+		d.count(ctx, OpIsNearDuplicate, MetricDuplicate, 1)
+		return matches[0], true, nil
+	}
+
+	if len(storeIfNot) > 0 {
+		err = d.storeFingerprint(ctx, cfg, dedupHash, signature, storeIfNot[0])
+		if err != nil {
+			d.fail(ctx, OpIsNearDuplicate, err)
+			d.logger.With("error", err).Error("failed to store fingerprint at IsNearDuplicate; %s", err.Error())
+		} else {
+			d.count(ctx, OpIsNearDuplicate, MetricStore, 1)
+		}
+	}
+	return NearDuplicate{}, false, nil
+}
+
+// FindSimilar returns the stored items similar to the entity, most similar first
+func (d *Deduper) FindSimilar(ctx context.Context, entity any, strategy HashStrategy) ([]NearDuplicate, error) {
+	_, _, matches, err := d.findSimilar(ctx, d.similarityConfig(), entity, strategy)
+	return matches, err
+}
+
+// StoreFingerprint indexes the signature of the entity so later similar entities find it.
+// It returns the dedup hash of the entity.
+func (d *Deduper) StoreFingerprint(ctx context.Context, entity any, strategy HashStrategy, expiration time.Duration) ([]byte, error) {
+	cfg := d.similarityConfig()
+
+	dedupHash, err := d.Hash(ctx, entity, strategy, false)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := d.signature(ctx, cfg, entity)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.storeFingerprint(ctx, cfg, dedupHash, signature, expiration)
+	if err != nil {
+		return nil, err
+	}
+	return dedupHash, nil
+}
+
+func (d *Deduper) findSimilar(ctx context.Context, cfg SimilarityConfig, entity any, strategy HashStrategy) ([]byte, []uint64, []NearDuplicate, error) {
+	dedupHash, err := d.Hash(ctx, entity, strategy, false)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	signature, err := d.signature(ctx, cfg, entity)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Every item sharing a bucket with the entity is a candidate
+	var candidates [][]byte
+	seen := map[string]struct{}{}
+	for band, id := range cfg.buckets(signature) {
+		members, err := d.bucketMembers(ctx, d.bucketKey(ctx, band, id))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		for _, member := range members {
+			if _, ok := seen[string(member)]; ok {
+				continue
+			}
+			seen[string(member)] = struct{}{}
+			candidates = append(candidates, member)
+		}
+	}
+
+	var matches []NearDuplicate
+	for _, candidate := range candidates {
+		raw, err := d.storage.Get(ctx, d.taggedKey(ctx, fingerprintTag, candidate))
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
+		}
+		if raw == nil {
+			continue // expired, still listed in a bucket
+		}
+
+		stored, ok := decodeSignature(raw)
+		if !ok || len(stored) != len(signature) {
+			continue // written with another method or signature size
+		}
+
+		similarity, ok := cfg.similar(signature, stored)
+		if ok {
+			matches = append(matches, NearDuplicate{Hash: candidate, Key: d.buildKey(ctx, candidate), Similarity: similarity})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Similarity > matches[j].Similarity })
+	return dedupHash, signature, matches, nil
+}
+
+// storeFingerprint writes the signature and adds dedupHash to its LSH buckets. Buckets are
+// updated with a non-atomic Get + SetEX, so concurrent writers may drop each other from a
+// bucket; the item is still found through its other bands.
+func (d *Deduper) storeFingerprint(ctx context.Context, cfg SimilarityConfig, dedupHash []byte, signature []uint64, expiration time.Duration) error {
+	err := d.storage.SetEX(ctx, d.taggedKey(ctx, fingerprintTag, dedupHash), encodeSignature(signature), expiration)
+	if err != nil {
+		return errors.Wrap(err, "failed to store fingerprint; %s", err.Error(), DedupStorageErrorCode)
+	}
+
+	for band, id := range cfg.buckets(signature) {
+		key := d.bucketKey(ctx, band, id)
+		members, err := d.bucketMembers(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		kept := members[:0]
+		for _, member := range members {
+			if !bytes.Equal(member, dedupHash) {
+				kept = append(kept, member)
+			}
+		}
+		kept = append(kept, dedupHash)
+		if len(kept) > cfg.BucketSize {
+			kept = kept[len(kept)-cfg.BucketSize:]
+		}
+
+		// a bucket lives as long as its longest-lived member
+		bucketExpiration := expiration
+		ttl, err := d.storage.TTL(ctx, key)
+		if err != nil {
+			return errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
+		}
+		switch {
+		case expiration <= 0 || ttl == -1:
+			bucketExpiration = 0
+		case ttl > expiration:
+			bucketExpiration = ttl
+		}
+
+		err = d.storage.SetEX(ctx, key, encodeMembers(kept), bucketExpiration)
+		if err != nil {
+			return errors.Wrap(err, "failed to store fingerprint bucket; %s", err.Error(), DedupStorageErrorCode)
+		}
+	}
+	return nil
+}
+
+func (d *Deduper) signature(ctx context.Context, cfg SimilarityConfig, entity any) ([]uint64, error) {
+	features, err := cfg.Features(ctx, entity)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to extract features; %s", err.Error(), DedupSerializerErrorCode)
+	}
+
+	if cfg.Method == MinHashMethod {
+		return MinHash(features, cfg.Bands*cfg.Rows), nil
+	}
+	return []uint64{SimHash(features)}, nil
+}
+
+func (d *Deduper) bucketMembers(ctx context.Context, key []byte) ([][]byte, error) {
+	raw, err := d.storage.Get(ctx, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "storage error; %s", err.Error(), DedupStorageErrorCode)
+	}
+	return decodeMembers(raw), nil
+}
+
+// bucketKey is "<prefix>\x00l:<band>:<bucket id in hex>"
+func (d *Deduper) bucketKey(ctx context.Context, band int, id uint64) []byte {
+	suffix := make([]byte, 0, 20)
+	suffix = strconv.AppendInt(suffix, int64(band), 10)
+	suffix = append(suffix, ':')
+	return d.taggedKey(ctx, bucketTag, strconv.AppendUint(suffix, id, 16))
+}
+
+// buckets returns the LSH bucket id of the signature in every band.
+// SimHash bands are slices of the 64 bits; MinHash bands hash Rows consecutive values.
+func (cfg SimilarityConfig) buckets(signature []uint64) []uint64 {
+	ids := make([]uint64, cfg.Bands)
+
+	if cfg.Method == MinHashMethod {
+		buf := make([]byte, 8)
+		for band := range ids {
+			h := fnv.New64a()
+			for _, v := range signature[band*cfg.Rows : (band+1)*cfg.Rows] {
+				binary.BigEndian.PutUint64(buf, v)
+				h.Write(buf)
+			}
+			ids[band] = h.Sum64()
+		}
+		return ids
+	}
+
+	width := 64 / cfg.Bands
+	for band := range ids {
+		shift := band * width
+		bits := width
+		if band == cfg.Bands-1 {
+			bits = 64 - shift
+		}
+		ids[band] = signature[0] >> shift
+		if bits < 64 {
+			ids[band] &= 1<<bits - 1
+		}
+	}
+	return ids
+}
+
+// similar scores two signatures and reports whether they are within the threshold
+func (cfg SimilarityConfig) similar(a, b []uint64) (float64, bool) {
+	if cfg.Method == MinHashMethod {
+		jaccard := Jaccard(a, b)
+		return jaccard, jaccard >= cfg.MinJaccard
+	}
+
+	distance := HammingDistance(a[0], b[0])
+	return 1 - float64(distance)/64, distance <= cfg.MaxDistance
+}
+
+func encodeSignature(signature []uint64) []byte {
+	raw := make([]byte, 8*len(signature))
+	for i, v := range signature {
+		binary.BigEndian.PutUint64(raw[8*i:], v)
+	}
+	return raw
+}
+
+func decodeSignature(raw []byte) ([]uint64, bool) {
+	if len(raw) == 0 || len(raw)%8 != 0 {
+		return nil, false
+	}
+
+	signature := make([]uint64, len(raw)/8)
+	for i := range signature {
+		signature[i] = binary.BigEndian.Uint64(raw[8*i:])
+	}
+	return signature, true
+}
+
+// encodeMembers writes bucket members as hex lines, dedup hashes may hold any byte
+func encodeMembers(members [][]byte) []byte {
+	var buf bytes.Buffer
+	for i, member := range members {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(hex.EncodeToString(member))
+	}
+	return buf.Bytes()
+}
+
+func decodeMembers(raw []byte) [][]byte {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var members [][]byte
+	for _, line := range bytes.Split(raw, []byte("\n")) {
+		member, err := hex.DecodeString(string(line))
+		if err == nil {
+			members = append(members, member)
+		}
+	}
+	return members
+}
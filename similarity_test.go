@@ -0,0 +1,156 @@
+package dedup
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+type Ticket struct {
+	ID   string
+	Body string
+}
+
+func TestNearDuplicate(t *testing.T) {
+	ctx := context.Background()
+	handler := func(_ context.Context, ticket Ticket) ([]byte, error) { return []byte(ticket.ID), nil }
+	features := func(_ context.Context, entity any) ([][]byte, error) {
+		return Shingles(entity.(Ticket).Body, 3), nil
+	}
+
+	t.Run("SimHash on serialized entities", func(t *testing.T) {
+		deduper := New(func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}))
+
+		_, isDuplicate, err := deduper.IsNearDuplicate(ctx, article, DefaultHashStrategy(), time.Hour)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		match, isDuplicate, err := deduper.IsNearDuplicate(ctx, strings.Replace(article, "long debate", "heated debate", 1), DefaultHashStrategy(), time.Hour)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+		assert.GreaterOrEqual(t, match.Similarity, 1-3.0/64)
+
+		articleHash, err := deduper.Hash(ctx, article, DefaultHashStrategy(), false)
+		assert.NoError(t, err)
+		assert.Equal(t, articleHash, match.Hash)
+		assert.Equal(t, deduper.buildKey(ctx, articleHash), match.Key)
+
+		_, isDuplicate, err = deduper.IsNearDuplicate(ctx, unrelated, DefaultHashStrategy(), time.Hour)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+
+	t.Run("MinHash on Redis storage", func(t *testing.T) {
+		mr, err := miniredis.Run()
+		assert.NoError(t, err)
+		defer mr.Close()
+
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer client.Close()
+
+		deduper := New(handler, NewRedisStorage(ctx, client), WithPrefix("tickets:"),
+			WithSimilarity(SimilarityConfig{Method: MinHashMethod, Features: features, MinJaccard: 0.7}))
+
+		dedupHash, err := deduper.StoreFingerprint(ctx, Ticket{ID: "t-1", Body: article}, DefaultHashStrategy(), time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("t-1"), dedupHash)
+		assert.Equal(t, time.Hour, mr.TTL("tickets:\x00f:t-1"))
+
+		buckets, err := client.Keys(ctx, "tickets:\x00l:*").Result()
+		assert.NoError(t, err)
+		assert.Len(t, buckets, 16)
+
+		matches, err := deduper.FindSimilar(ctx, Ticket{ID: "t-2", Body: strings.Replace(article, "Tuesday", "Wednesday", 1)}, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.Len(t, matches, 1)
+		assert.Equal(t, []byte("tickets:t-1"), matches[0].Key)
+		assert.InDelta(t, 0.9, matches[0].Similarity, 0.1)
+
+		matches, err = deduper.FindSimilar(ctx, Ticket{ID: "t-3", Body: unrelated}, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.Empty(t, matches)
+	})
+
+	t.Run("Fingerprints are never entity duplicates", func(t *testing.T) {
+		deduper := New(func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}))
+
+		dedupHash, err := deduper.StoreFingerprint(ctx, "abc", DefaultHashStrategy(), time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("abc"), dedupHash)
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, "fp:abc", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		isDuplicate, err = deduper.IsDuplicate(ctx, "abc", DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+	})
+
+	t.Run("Most similar first", func(t *testing.T) {
+		deduper := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}),
+			WithSimilarity(SimilarityConfig{Method: MinHashMethod, Features: features, MinJaccard: 0.5}))
+
+		_, err := deduper.StoreFingerprint(ctx, Ticket{ID: "t-1", Body: strings.Replace(article, "Tuesday", "Wednesday", 1)}, DefaultHashStrategy(), time.Hour)
+		assert.NoError(t, err)
+		_, err = deduper.StoreFingerprint(ctx, Ticket{ID: "t-2", Body: article}, DefaultHashStrategy(), time.Hour)
+		assert.NoError(t, err)
+
+		match, isDuplicate, err := deduper.IsNearDuplicate(ctx, Ticket{ID: "t-3", Body: article}, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+		assert.Equal(t, []byte("t-2"), match.Hash)
+		assert.Equal(t, 1.0, match.Similarity)
+	})
+
+	t.Run("Expired fingerprints are skipped", func(t *testing.T) {
+		clock := newFakeClock()
+		deduper := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1, Now: clock.Now}),
+			WithSimilarity(SimilarityConfig{Features: features}))
+
+		_, err := deduper.StoreFingerprint(ctx, Ticket{ID: "t-1", Body: article}, DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		_, err = deduper.StoreFingerprint(ctx, Ticket{ID: "t-2", Body: article}, DefaultHashStrategy(), time.Hour)
+		assert.NoError(t, err)
+
+		clock.Advance(2 * time.Minute)
+		matches, err := deduper.FindSimilar(ctx, Ticket{ID: "t-3", Body: article}, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.Len(t, matches, 1)
+		assert.Equal(t, []byte("t-2"), matches[0].Hash)
+	})
+
+	t.Run("Buckets keep the newest items", func(t *testing.T) {
+		deduper := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}),
+			WithSimilarity(SimilarityConfig{Features: features, BucketSize: 2}))
+
+		for _, id := range []string{"t-1", "t-2", "t-3"} {
+			_, err := deduper.StoreFingerprint(ctx, Ticket{ID: id, Body: article}, DefaultHashStrategy(), time.Hour)
+			assert.NoError(t, err)
+		}
+
+		matches, err := deduper.FindSimilar(ctx, Ticket{ID: "t-4", Body: article}, DefaultHashStrategy())
+		assert.NoError(t, err)
+		var hashes []string
+		for _, match := range matches {
+			hashes = append(hashes, string(match.Hash))
+		}
+		assert.ElementsMatch(t, []string{"t-2", "t-3"}, hashes)
+	})
+
+	t.Run("Storage errors", func(t *testing.T) {
+		storage := &MockStorage{
+			getFunc: func(ctx context.Context, key []byte) ([]byte, error) { return nil, assert.AnError },
+		}
+		deduper := New(handler, storage, WithSimilarity(SimilarityConfig{Features: features}))
+
+		_, _, err := deduper.IsNearDuplicate(ctx, Ticket{ID: "t-1", Body: article}, DefaultHashStrategy())
+		assert.Error(t, err)
+		assert.True(t, hasCode(err, DedupStorageErrorCode))
+	})
+}
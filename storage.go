@@ -0,0 +1,251 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+)
+
+// supports reports whether storage has capability T. Decorators that implement every
+// capability through the fallback helpers expose Unwrap, so the check is made on the
+// storage they wrap.
+func supports[T any](storage Storage) bool {
+	for {
+		u, ok := storage.(interface{ Unwrap() Storage })
+		if !ok {
+			break
+		}
+		storage = u.Unwrap()
+	}
+
+	_, ok := storage.(T)
+	return ok
+}
+
+// AtomicStorage is an optional Storage capability used for first-writer-wins claims
+type AtomicStorage interface {
+	// SetNX stores the value only if the key does not exist yet and reports whether it was written
+	SetNX(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) (bool, error)
+}
+
+// SetNX claims key through AtomicStorage when available, falling back to a non-atomic Exists + SetEX
+func SetNX(ctx context.Context, storage Storage, key []byte, value []byte, expiration time.Duration) (bool, error) {
+	if atomic, ok := storage.(AtomicStorage); ok {
+		return atomic.SetNX(ctx, key, value, expiration)
+	}
+
+	exists, err := storage.Exists(ctx, key)
+	if err != nil || exists {
+		return false, err
+	}
+
+	err = storage.SetEX(ctx, key, value, expiration)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// BatchStorage is an optional Storage capability for checking and storing many keys in one round trip
+type BatchStorage interface {
+	// ExistsBatch reports, in input order, whether each key exists
+	ExistsBatch(ctx context.Context, keys [][]byte) ([]bool, error)
+	// SetEXBatch stores values[i] under keys[i], all with the same expiration
+	SetEXBatch(ctx context.Context, keys [][]byte, values [][]byte, expiration ...time.Duration) error
+}
+
+// ExistsBatch checks keys through BatchStorage when available, falling back to one Exists per key
+func ExistsBatch(ctx context.Context, storage Storage, keys [][]byte) ([]bool, error) {
+	if batch, ok := storage.(BatchStorage); ok {
+		return batch.ExistsBatch(ctx, keys)
+	}
+
+	exists := make([]bool, len(keys))
+	for i, key := range keys {
+		var err error
+		exists[i], err = storage.Exists(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return exists, nil
+}
+
+// SetEXBatch stores keys through BatchStorage when available, falling back to one SetEX per key
+func SetEXBatch(ctx context.Context, storage Storage, keys [][]byte, values [][]byte, expiration time.Duration) error {
+	if batch, ok := storage.(BatchStorage); ok {
+		return batch.SetEXBatch(ctx, keys, values, expiration)
+	}
+
+	for i, key := range keys {
+		err := storage.SetEX(ctx, key, values[i], expiration)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExpireStorage is an optional Storage capability for refreshing the expiration of an existing key
+type ExpireStorage interface {
+	// Expire sets a new expiration on key and reports whether the key exists
+	Expire(ctx context.Context, key []byte, expiration time.Duration) (bool, error)
+}
+
+// Expire refreshes the key expiration through ExpireStorage when available, falling back to a non-atomic Get + SetEX
+func Expire(ctx context.Context, storage Storage, key []byte, expiration time.Duration) (bool, error) {
+	if exp, ok := storage.(ExpireStorage); ok {
+		return exp.Expire(ctx, key, expiration)
+	}
+
+	value, err := storage.Get(ctx, key)
+	if err != nil || value == nil {
+		return false, err
+	}
+
+	err = storage.SetEX(ctx, key, value, expiration)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndSwapStorage is an optional Storage capability for comparing and replacing a value in one atomic step
+type CompareAndSwapStorage interface {
+	// CompareAndSwap reports whether the stored value equals value. When it does not, or the key
+	// is missing, and replace is set, value is stored with the expiration; replaced reports that write.
+	CompareAndSwap(ctx context.Context, key []byte, value []byte, replace bool, expiration ...time.Duration) (matched bool, replaced bool, err error)
+}
+
+// CompareAndSwap compares and replaces through CompareAndSwapStorage when available, falling back to a non-atomic Get + SetEX
+func CompareAndSwap(ctx context.Context, storage Storage, key []byte, value []byte, replace bool, expiration time.Duration) (bool, bool, error) {
+	if cas, ok := storage.(CompareAndSwapStorage); ok {
+		return cas.CompareAndSwap(ctx, key, value, replace, expiration)
+	}
+
+	current, err := storage.Get(ctx, key)
+	if err != nil {
+		return false, false, err
+	}
+	if current != nil && bytes.Equal(current, value) {
+		return true, false, nil
+	}
+	if !replace {
+		return false, false, nil
+	}
+
+	err = storage.SetEX(ctx, key, value, expiration)
+	if err != nil {
+		return false, false, err
+	}
+	return false, true, nil
+}
+
+// CounterStorage is an optional Storage capability for counting occurrences of a key in a fixed window
+type CounterStorage interface {
+	// Incr increments the integer counter under key, creating it at 1 with the expiration, and returns
+	// the new count and the time left before it expires, -1 when it never does. The expiration of an
+	// existing counter is left alone, so the window is fixed by its first increment.
+	Incr(ctx context.Context, key []byte, expiration time.Duration) (int64, time.Duration, error)
+}
+
+// Incr increments a counter through CounterStorage when available, falling back to a non-atomic Get + TTL + SetEX
+func Incr(ctx context.Context, storage Storage, key []byte, expiration time.Duration) (int64, time.Duration, error) {
+	if counter, ok := storage.(CounterStorage); ok {
+		return counter.Incr(ctx, key, expiration)
+	}
+
+	current, err := storage.Get(ctx, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	if current == nil {
+		err = storage.SetEX(ctx, key, []byte("1"), expiration)
+		if err != nil {
+			return 0, 0, err
+		}
+		return 1, remainingOf(expiration), nil
+	}
+
+	count, err := parseCounter(current)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	ttl, err := storage.TTL(ctx, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch {
+	case ttl == -2: // expired since the Get
+		count, ttl = 0, expiration
+	case ttl == -1 && expiration > 0: // never expired, start the window now
+		ttl = expiration
+	}
+
+	count++
+	err = storage.SetEX(ctx, key, []byte(strconv.FormatInt(count, 10)), ttl)
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, remainingOf(ttl), nil
+}
+
+func parseCounter(value []byte) (int64, error) {
+	count, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, errors.New("value is not an integer counter", DedupInvalidCounterErrorCode)
+	}
+	return count, nil
+}
+
+// remainingOf is the TTL reported for an expiration, -1 when it never expires
+func remainingOf(expiration time.Duration) time.Duration {
+	if expiration <= 0 {
+		return -1
+	}
+	return expiration
+}
+
+// ScanStorage is an optional Storage capability for enumerating keys, used by ScopeKeys and the Purge fallback
+type ScanStorage interface {
+	// Scan calls fn with every live key starting with prefix, stopping at the first error fn returns.
+	// Keys written or deleted while scanning may or may not be seen, fn may delete the key it is given.
+	Scan(ctx context.Context, prefix []byte, fn func(key []byte) error) error
+}
+
+// Scan enumerates keys through ScanStorage; there is no fallback, other storages fail
+func Scan(ctx context.Context, storage Storage, prefix []byte, fn func(key []byte) error) error {
+	if scanner, ok := storage.(ScanStorage); ok {
+		return scanner.Scan(ctx, prefix, fn)
+	}
+	return errors.New("storage cannot enumerate keys", DedupUnsupportedStorageErrorCode)
+}
+
+// PurgeStorage is an optional Storage capability for deleting every key under a prefix in bulk, used by PurgeScope
+type PurgeStorage interface {
+	// Purge deletes every live key starting with prefix and returns how many were deleted.
+	// Keys written while purging may or may not be deleted.
+	Purge(ctx context.Context, prefix []byte) (int64, error)
+}
+
+// Purge deletes keys through PurgeStorage when available, falling back to Scan with one Del per key
+func Purge(ctx context.Context, storage Storage, prefix []byte) (int64, error) {
+	if purger, ok := storage.(PurgeStorage); ok {
+		return purger.Purge(ctx, prefix)
+	}
+
+	var purged int64
+	err := Scan(ctx, storage, prefix, func(key []byte) error {
+		deleted, err := storage.Del(ctx, key)
+		if deleted {
+			purged++
+		}
+		return err
+	})
+	return purged, err
+}
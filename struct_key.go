@@ -0,0 +1,311 @@
+package dedup
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+)
+
+// maxKeyDepth bounds nesting so pointer cycles fail instead of recursing forever
+const maxKeyDepth = 32
+
+// Value tags of the StructKey encoding, so values of different kinds never encode alike
+const (
+	keyNil     = 'n'
+	keyBool    = 'b'
+	keyInt     = 'i'
+	keyUint    = 'u'
+	keyFloat   = 'f'
+	keyString  = 's'
+	keyBytes   = 'y'
+	keyTime    = 't'
+	keyList    = 'l'
+	keyMap     = 'm'
+	keyStruct  = 'r'
+	keyEncoded = 'x'
+)
+
+var (
+	timeType            = reflect.TypeFor[time.Time]()
+	binaryMarshalerType = reflect.TypeFor[encoding.BinaryMarshaler]()
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// structPlans caches the fields encoded for nested struct types
+var structPlans sync.Map // reflect.Type -> *structPlan
+
+type structPlan struct {
+	fields []planField
+}
+
+type planField struct {
+	name  string
+	index int
+}
+
+// StructKey returns a key handler, usable as the handler of New and NewDeduper, that encodes
+// selected fields of a struct T, or of the struct T points to. The fields are the given ones,
+// in that order, or else those tagged `dedup:"key"`. Nested structs encode their tagged fields,
+// or all exported fields when none is tagged; `dedup:"-"` excludes a field. Nested structs with
+// no such field, such as netip.Addr, encode through their encoding.BinaryMarshaler or
+// encoding.TextMarshaler and fail when they implement neither.
+//
+// The encoding is canonical and unambiguous: every value is tagged with its kind and strings,
+// byte slices, lists and maps are length-prefixed. Pointers encode as the value they point to,
+// map entries are sorted, time.Time is normalized to UTC and integers of any size encode alike.
+// Field plans are built once per type with reflection.
+func StructKey[T any](fields ...string) func(ctx context.Context, entity T) ([]byte, error) {
+	plan, planErr := rootPlan(reflect.TypeFor[T](), fields)
+
+	return func(_ context.Context, entity T) ([]byte, error) {
+		if planErr != nil {
+			return nil, planErr
+		}
+
+		v := reflect.ValueOf(&entity).Elem()
+		for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil, errors.New("entity is nil", DedupEntityNilErrorCode)
+			}
+			v = v.Elem()
+		}
+
+		return appendFields(nil, v, plan, 0)
+	}
+}
+
+// rootPlan builds the plan of the entity type from the named fields or the `dedup:"key"` tags
+func rootPlan(typ reflect.Type, names []string) (*structPlan, error) {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil, errors.New("StructKey needs a struct type, got '%s'", typ.String(), DedupInvalidKeyFieldErrorCode)
+	}
+
+	if len(names) == 0 {
+		plan := taggedPlan(typ)
+		if len(plan.fields) == 0 {
+			return nil, errors.New("type '%s' has no fields tagged `dedup:\"key\"`", typ.String(), DedupInvalidKeyFieldErrorCode)
+		}
+		return plan, nil
+	}
+
+	plan := &structPlan{}
+	for _, name := range names {
+		field, ok := typ.FieldByName(name)
+		if !ok || len(field.Index) != 1 || !field.IsExported() {
+			return nil, errors.New("type '%s' has no exported field '%s'", typ.String(), name, DedupInvalidKeyFieldErrorCode)
+		}
+		plan.fields = append(plan.fields, planField{name: field.Name, index: field.Index[0]})
+	}
+	return plan, nil
+}
+
+// taggedPlan returns the exported fields tagged `dedup:"key"`
+func taggedPlan(typ reflect.Type) *structPlan {
+	plan := &structPlan{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.IsExported() && hasKeyTag(field, "key") {
+			plan.fields = append(plan.fields, planField{name: field.Name, index: i})
+		}
+	}
+	return plan
+}
+
+// nestedPlan returns the cached plan of a nested struct type
+func nestedPlan(typ reflect.Type) *structPlan {
+	if cached, ok := structPlans.Load(typ); ok {
+		return cached.(*structPlan)
+	}
+
+	plan := taggedPlan(typ)
+	if len(plan.fields) == 0 {
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.IsExported() && !hasKeyTag(field, "-") {
+				plan.fields = append(plan.fields, planField{name: field.Name, index: i})
+			}
+		}
+	}
+
+	cached, _ := structPlans.LoadOrStore(typ, plan)
+	return cached.(*structPlan)
+}
+
+func hasKeyTag(field reflect.StructField, option string) bool {
+	for _, tag := range strings.Split(field.Tag.Get("dedup"), ",") {
+		if strings.TrimSpace(tag) == option {
+			return true
+		}
+	}
+	return false
+}
+
+func appendFields(buf []byte, v reflect.Value, plan *structPlan, depth int) ([]byte, error) {
+	var err error
+	for _, field := range plan.fields {
+		buf = appendLength(buf, field.name)
+		buf, err = appendValue(buf, v.Field(field.index), depth+1)
+		if err != nil && depth == 0 {
+			return nil, errors.Wrap(err, "field '%s'; %s", field.name, err.Error(), DedupInvalidKeyFieldErrorCode)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendValue(buf []byte, v reflect.Value, depth int) ([]byte, error) {
+	if depth > maxKeyDepth {
+		return nil, errors.New("nested deeper than %d levels, is there a cycle?", maxKeyDepth, DedupInvalidKeyFieldErrorCode)
+	}
+
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return append(buf, keyNil), nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return append(buf, keyNil), nil
+	}
+
+	if v.Type() == timeType {
+		t := v.Interface().(time.Time)
+		return appendLength(append(buf, keyTime), t.UTC().Format(time.RFC3339Nano)), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, keyBool, 1), nil
+		}
+		return append(buf, keyBool, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(append(buf, keyInt), v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return binary.AppendUvarint(append(buf, keyUint), v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f == 0 {
+			f = 0 // -0 and +0 are equal
+		}
+		return binary.BigEndian.AppendUint64(append(buf, keyFloat), math.Float64bits(f)), nil
+	case reflect.String:
+		return appendLength(append(buf, keyString), v.String()), nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendLength(append(buf, keyBytes), string(bytesOf(v))), nil
+		}
+		return appendList(buf, v, depth)
+	case reflect.Map:
+		return appendMap(buf, v, depth)
+	case reflect.Struct:
+		plan := nestedPlan(v.Type())
+		if len(plan.fields) == 0 {
+			return appendMarshaled(buf, v)
+		}
+		buf = binary.AppendUvarint(append(buf, keyStruct), uint64(len(plan.fields)))
+		return appendFields(buf, v, plan, depth)
+	default:
+		return nil, errors.New("unsupported kind '%s'", v.Kind().String(), DedupInvalidKeyFieldErrorCode)
+	}
+}
+
+// appendMarshaled encodes a struct with no encodable fields through its BinaryMarshaler or
+// TextMarshaler; encoding it as zero fields would give every value of the type the same key
+func appendMarshaled(buf []byte, v reflect.Value) ([]byte, error) {
+	if !v.CanAddr() {
+		addressable := reflect.New(v.Type()).Elem()
+		addressable.Set(v)
+		v = addressable
+	}
+	ptr := v.Addr()
+
+	var data []byte
+	var err error
+	switch {
+	case ptr.Type().Implements(binaryMarshalerType):
+		data, err = ptr.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	case ptr.Type().Implements(textMarshalerType):
+		data, err = ptr.Interface().(encoding.TextMarshaler).MarshalText()
+	default:
+		return nil, errors.New("struct '%s' has no encodable fields", v.Type().String(), DedupInvalidKeyFieldErrorCode)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "struct '%s'; %s", v.Type().String(), err.Error(), DedupInvalidKeyFieldErrorCode)
+	}
+	return appendLength(append(buf, keyEncoded), string(data)), nil
+}
+
+func appendList(buf []byte, v reflect.Value, depth int) ([]byte, error) {
+	buf = binary.AppendUvarint(append(buf, keyList), uint64(v.Len()))
+
+	var err error
+	for i := 0; i < v.Len(); i++ {
+		buf, err = appendValue(buf, v.Index(i), depth+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// appendMap writes entries sorted by their encoded key, whatever the key type
+func appendMap(buf []byte, v reflect.Value, depth int) ([]byte, error) {
+	type entry struct {
+		key   []byte
+		value reflect.Value
+	}
+
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := appendValue(nil, iter.Key(), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{key: key, value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].key, entries[j].key) < 0 })
+
+	buf = binary.AppendUvarint(append(buf, keyMap), uint64(len(entries)))
+
+	var err error
+	for _, e := range entries {
+		buf = append(buf, e.key...)
+		buf, err = appendValue(buf, e.value, depth+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendLength(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// bytesOf returns the bytes of a byte slice or array, arrays may not be addressable
+func bytesOf(v reflect.Value) []byte {
+	if v.Kind() == reflect.Slice {
+		return v.Bytes()
+	}
+
+	b := make([]byte, v.Len())
+	reflect.Copy(reflect.ValueOf(b), v)
+	return b
+}
@@ -0,0 +1,186 @@
+package dedup
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type Address struct {
+	City    string
+	Zip     string
+	Comment string `dedup:"-"`
+}
+
+type Shipment struct {
+	OrderID   string            `dedup:"key"`
+	Customer  *Address          `dedup:"key"`
+	Lines     []int             `dedup:"key"`
+	Labels    map[string]string `dedup:"key"`
+	CreatedAt time.Time         `dedup:"key"`
+	Note      string
+}
+
+func TestStructKey(t *testing.T) {
+	ctx := context.Background()
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	shipment := Shipment{
+		OrderID:   "order-1",
+		Customer:  &Address{City: "Lisbon", Zip: "1000"},
+		Lines:     []int{1, 2},
+		Labels:    map[string]string{"b": "2", "a": "1"},
+		CreatedAt: created,
+		Note:      "leave at the door",
+	}
+
+	t.Run("Tagged fields", func(t *testing.T) {
+		handler := StructKey[Shipment]()
+
+		key, err := handler(ctx, shipment)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, key)
+
+		// Untagged and excluded fields, map order and time zones do not change the key
+		same := shipment
+		same.Note = "ring twice"
+		same.Customer = &Address{City: "Lisbon", Zip: "1000", Comment: "blue door"}
+		same.Labels = map[string]string{"a": "1", "b": "2"}
+		same.CreatedAt = created.In(time.FixedZone("WEST", 3600))
+		sameKey, err := handler(ctx, same)
+		assert.NoError(t, err)
+		assert.Equal(t, key, sameKey)
+
+		// Pointers to the entity encode like the entity
+		pointerKey, err := StructKey[*Shipment]()(ctx, &shipment)
+		assert.NoError(t, err)
+		assert.Equal(t, key, pointerKey)
+
+		other := shipment
+		other.Lines = []int{1, 3}
+		otherKey, err := handler(ctx, other)
+		assert.NoError(t, err)
+		assert.NotEqual(t, key, otherKey)
+	})
+
+	t.Run("Length prefixes keep fields apart", func(t *testing.T) {
+		type Pair struct {
+			A, B string
+		}
+		handler := StructKey[Pair]("A", "B")
+
+		ab, err := handler(ctx, Pair{A: "ab", B: "c"})
+		assert.NoError(t, err)
+		a, err := handler(ctx, Pair{A: "a", B: "bc"})
+		assert.NoError(t, err)
+		assert.NotEqual(t, ab, a)
+	})
+
+	t.Run("Kinds never collide", func(t *testing.T) {
+		type Value struct {
+			V any
+		}
+		handler := StructKey[Value]("V")
+
+		keys := map[string]any{}
+		for _, v := range []any{nil, "1", 1, uint(1), 1.0, true, []byte("1"), []string{"1"}, map[string]int{"1": 1}} {
+			key, err := handler(ctx, Value{V: v})
+			assert.NoError(t, err)
+			assert.NotContains(t, keys, string(key), "%v collides with %v", v, keys[string(key)])
+			keys[string(key)] = v
+		}
+
+		// Integers of any size encode alike
+		small, err := handler(ctx, Value{V: int8(7)})
+		assert.NoError(t, err)
+		large, err := handler(ctx, Value{V: int64(7)})
+		assert.NoError(t, err)
+		assert.Equal(t, small, large)
+	})
+
+	t.Run("Structs without exported fields", func(t *testing.T) {
+		type Peer struct {
+			Addr netip.Addr `dedup:"key"`
+		}
+		handler := StructKey[Peer]()
+
+		a, err := handler(ctx, Peer{Addr: netip.MustParseAddr("10.0.0.1")})
+		assert.NoError(t, err)
+		b, err := handler(ctx, Peer{Addr: netip.MustParseAddr("192.168.1.1")})
+		assert.NoError(t, err)
+		assert.NotEqual(t, a, b)
+
+		same, err := handler(ctx, Peer{Addr: netip.MustParseAddr("10.0.0.1")})
+		assert.NoError(t, err)
+		assert.Equal(t, a, same)
+
+		type opaque struct {
+			id int
+		}
+		type Wrapper struct {
+			Opaque opaque `dedup:"key"`
+		}
+		_, err = StructKey[Wrapper]()(ctx, Wrapper{Opaque: opaque{id: 1}})
+		assert.True(t, hasCode(err, DedupInvalidKeyFieldErrorCode))
+		assert.ErrorContains(t, err, "no encodable fields")
+	})
+
+	t.Run("Field list order", func(t *testing.T) {
+		byID, err := StructKey[Shipment]("OrderID", "Note")(ctx, shipment)
+		assert.NoError(t, err)
+		byNote, err := StructKey[Shipment]("Note", "OrderID")(ctx, shipment)
+		assert.NoError(t, err)
+		assert.NotEqual(t, byID, byNote)
+	})
+
+	t.Run("Invalid types and fields", func(t *testing.T) {
+		_, err := StructKey[string]()(ctx, "order-1")
+		assert.True(t, hasCode(err, DedupInvalidKeyFieldErrorCode))
+
+		_, err = StructKey[Address]()(ctx, Address{})
+		assert.ErrorContains(t, err, "no fields tagged")
+
+		_, err = StructKey[Shipment]("Missing")(ctx, shipment)
+		assert.ErrorContains(t, err, "no exported field 'Missing'")
+
+		type Unsupported struct {
+			C chan int `dedup:"key"`
+		}
+		_, err = StructKey[Unsupported]()(ctx, Unsupported{C: make(chan int)})
+		assert.ErrorContains(t, err, "unsupported kind 'chan'")
+
+		_, err = StructKey[*Shipment]()(ctx, nil)
+		assert.True(t, hasCode(err, DedupEntityNilErrorCode))
+	})
+
+	t.Run("Cycles fail", func(t *testing.T) {
+		type Node struct {
+			Name string
+			Next *Node
+		}
+		type Graph struct {
+			Root *Node `dedup:"key"`
+		}
+
+		node := &Node{Name: "a"}
+		node.Next = node
+		_, err := StructKey[Graph]()(ctx, Graph{Root: node})
+		assert.ErrorContains(t, err, "cycle")
+	})
+
+	t.Run("As a Deduper handler", func(t *testing.T) {
+		deduper := New(StructKey[Shipment](), NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}))
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, shipment, DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		retry := shipment
+		retry.Note = "retried"
+		isDuplicate, err = deduper.IsDuplicate(ctx, retry, DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+	})
+}
@@ -0,0 +1,106 @@
+package dedup
+
+import "context"
+
+// Tracer starts a span around a Deduper operation, see WithTracer.
+// The oteldedup package adapts an OpenTelemetry tracer so the core stays dependency-free.
+type Tracer interface {
+	// Start starts the span of an operation, such as "is_duplicate", as a child of the span in ctx
+	Start(ctx context.Context, operation string) (context.Context, Span)
+}
+
+// Span is a traced Deduper operation.
+// Attributes are string, bool, int64 or float64 values:
+//   - dedup.prefix: the key prefix of the Deduper
+//   - dedup.key_mode: "hashed" or "raw", whether the entity key was hashed
+//   - dedup.decision: "duplicate" or "new", for IsDuplicate, IsValueDuplicate, IsDuplicateN and IsNearDuplicate
+//   - dedup.count: the int64 occurrence count of IsDuplicateN
+//   - dedup.similarity: the float64 similarity of the near-duplicate found by IsNearDuplicate
+//   - dedup.storage_error, dedup.serializer_error, dedup.matcher_error: set when one happened,
+//     including errors that are only logged, such as a failed store after a new entity
+type Span interface {
+	SetAttribute(key string, value any)
+	RecordError(err error)
+	End()
+}
+
+type spanKey struct{}
+
+// span is the Span of an operation on deduper; a nil span discards everything
+type span struct {
+	Span
+	deduper *Deduper
+}
+
+// startSpan starts the span of operation when tracing is enabled, carrying it in the returned ctx
+func (d *Deduper) startSpan(ctx context.Context, operation string) (context.Context, *span) {
+	if d.tracer == nil {
+		return ctx, nil
+	}
+
+	ctx, s := d.tracer.Start(ctx, operation)
+	s.SetAttribute("dedup.prefix", string(d.prefix))
+	if scope := d.scopeOf(ctx); scope != "" {
+		s.SetAttribute("dedup.scope", scope)
+	}
+
+	sp := &span{Span: s, deduper: d}
+	return context.WithValue(ctx, spanKey{}, sp), sp
+}
+
+// spanOf returns the span of the operation in progress on d, or nil
+func (d *Deduper) spanOf(ctx context.Context) *span {
+	s, _ := ctx.Value(spanKey{}).(*span)
+	if s == nil || s.deduper != d {
+		return nil
+	}
+	return s
+}
+
+func (s *span) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.SetAttribute(key, value)
+}
+
+func (s *span) keyMode(hashed bool) {
+	if hashed {
+		s.set("dedup.key_mode", "hashed")
+	} else {
+		s.set("dedup.key_mode", "raw")
+	}
+}
+
+func (s *span) mark(event MetricEvent) {
+	s.set("dedup."+string(event), true)
+}
+
+// decide records the duplicate decision, or err, and ends the span
+func (s *span) decide(isDuplicate bool, err error) {
+	if s == nil {
+		return
+	}
+	if err == nil {
+		if isDuplicate {
+			s.SetAttribute("dedup.decision", "duplicate")
+		} else {
+			s.SetAttribute("dedup.decision", "new")
+		}
+	}
+	s.end(err)
+}
+
+// end records err, classified like Metrics errors, and ends the span
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		if event, ok := eventOf(err); ok {
+			s.mark(event)
+		}
+		s.RecordError(err)
+	}
+	s.End()
+}
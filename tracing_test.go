@@ -0,0 +1,138 @@
+package dedup
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingTracer keeps every ended span
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	tracer     *recordingTracer
+	operation  string
+	parent     *recordedSpan
+	attributes map[string]any
+	errors     []error
+}
+
+type recordedSpanKey struct{}
+
+func (r *recordingTracer) Start(ctx context.Context, operation string) (context.Context, Span) {
+	parent, _ := ctx.Value(recordedSpanKey{}).(*recordedSpan)
+	s := &recordedSpan{tracer: r, operation: operation, parent: parent, attributes: map[string]any{}}
+	return context.WithValue(ctx, recordedSpanKey{}, s), s
+}
+
+func (s *recordedSpan) SetAttribute(key string, value any) { s.attributes[key] = value }
+func (s *recordedSpan) RecordError(err error)              { s.errors = append(s.errors, err) }
+func (s *recordedSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s)
+}
+
+func TestTracer(t *testing.T) {
+	ctx := context.Background()
+	handler := func(_ context.Context, s string) ([]byte, error) { return []byte(s), nil }
+
+	t.Run("Decisions and key mode", func(t *testing.T) {
+		tracer := &recordingTracer{}
+		deduper := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}), WithTracer(tracer), WithPrefix("orders:"))
+
+		_, err := deduper.IsDuplicate(ctx, "order-1", DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		_, err = deduper.IsDuplicate(ctx, "order-1", DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		_, err = deduper.IsValueDuplicate(ctx, "order-2", HashStrategy{KeyHashMode: AlwaysHash, ValueHashMode: AlwaysHash}, time.Minute)
+		assert.NoError(t, err)
+
+		assert.Len(t, tracer.spans, 3)
+		assert.Equal(t, OpIsDuplicate, tracer.spans[0].operation)
+		assert.Equal(t, map[string]any{"dedup.prefix": "orders:", "dedup.key_mode": "raw", "dedup.decision": "new"}, tracer.spans[0].attributes)
+		assert.Equal(t, "duplicate", tracer.spans[1].attributes["dedup.decision"])
+		assert.Equal(t, OpIsValueDuplicate, tracer.spans[2].operation)
+		assert.Equal(t, "hashed", tracer.spans[2].attributes["dedup.key_mode"])
+		assert.Equal(t, "new", tracer.spans[2].attributes["dedup.decision"])
+	})
+
+	t.Run("Store, StoreHash and TTL", func(t *testing.T) {
+		tracer := &recordingTracer{}
+		deduper := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}), WithTracer(tracer))
+
+		dedupHash, _, err := deduper.Store(ctx, "order-1", DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		_, err = deduper.StoreHash(ctx, []byte("order-2"), time.Minute)
+		assert.NoError(t, err)
+		_, err = deduper.TTL(ctx, dedupHash)
+		assert.NoError(t, err)
+
+		var operations []string
+		for _, s := range tracer.spans {
+			operations = append(operations, s.operation)
+			assert.Empty(t, s.errors)
+		}
+		assert.Equal(t, []string{OpStore, OpStoreHash, OpTTL}, operations)
+	})
+
+	t.Run("Spans are children of the ctx span", func(t *testing.T) {
+		tracer := &recordingTracer{}
+		deduper := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}), WithTracer(tracer))
+
+		parentCtx, parent := tracer.Start(ctx, "consume")
+		_, err := deduper.Seen(parentCtx, "order-1")
+		assert.NoError(t, err)
+
+		assert.Len(t, tracer.spans, 1)
+		assert.Same(t, parent, tracer.spans[0].parent)
+	})
+
+	t.Run("Storage errors", func(t *testing.T) {
+		tracer := &recordingTracer{}
+		storage := &MockStorage{
+			existsFunc: func(ctx context.Context, key []byte) (bool, error) { return false, nil },
+			setExFunc: func(ctx context.Context, key []byte, value []byte, expiration ...time.Duration) error {
+				return assert.AnError
+			},
+			ttlFunc: func(ctx context.Context, key []byte) (time.Duration, error) { return 0, assert.AnError },
+		}
+		deduper := New(handler, storage, WithTracer(tracer))
+
+		// The failed store is only logged, the decision stands
+		isDuplicate, err := deduper.IsDuplicate(ctx, "order-1", DefaultHashStrategy(), time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		_, err = deduper.TTL(ctx, []byte("order-1"))
+		assert.Error(t, err)
+
+		assert.Len(t, tracer.spans, 2)
+		assert.Equal(t, true, tracer.spans[0].attributes["dedup.storage_error"])
+		assert.Equal(t, "new", tracer.spans[0].attributes["dedup.decision"])
+		assert.Empty(t, tracer.spans[0].errors)
+
+		assert.Equal(t, true, tracer.spans[1].attributes["dedup.storage_error"])
+		assert.Len(t, tracer.spans[1].errors, 1)
+	})
+
+	t.Run("Untraced Dedupers ignore spans of others", func(t *testing.T) {
+		tracer := &recordingTracer{}
+		traced := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}), WithTracer(tracer))
+		untraced := New(handler, NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1}))
+
+		spanCtx, s := traced.startSpan(ctx, OpIsDuplicate)
+		_, err := untraced.IsDuplicate(spanCtx, "order-1", DefaultHashStrategy())
+		assert.NoError(t, err)
+		s.end(nil)
+
+		assert.Len(t, tracer.spans, 1)
+		assert.NotContains(t, tracer.spans[0].attributes, "dedup.key_mode")
+	})
+}
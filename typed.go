@@ -0,0 +1,172 @@
+package dedup
+
+import (
+	"context"
+	"hash"
+	"time"
+
+	"github.com/pixie-sh/errors-go"
+	"github.com/pixie-sh/logger-go/logger"
+)
+
+// TypedDeduper is a type-safe facade over Deduper; entity type errors fail at compile time
+type TypedDeduper[T any] struct {
+	deduper *Deduper
+}
+
+func NewTypedDeduper[T any](
+	handler func(ctx context.Context, t T) ([]byte, error),
+	storage Storage,
+	logger logger.Interface,
+	hasher func() hash.Hash,
+	matcher func(ctx context.Context, inputEntity T, storageEntity string) (bool, error),
+	serializer func(ctx context.Context, inputEntity T) (string, error),
+	customPrefix ...string,
+) *TypedDeduper[T] {
+	return &TypedDeduper[T]{
+		deduper: NewDeduper(handler, storage, logger, hasher, TypedMatcher(matcher), TypedSerializer(serializer), customPrefix...),
+	}
+}
+
+// TypedMatcher adapts a typed matcher to the untyped form accepted by NewDeduper
+func TypedMatcher[T any](matcher func(ctx context.Context, inputEntity T, storageEntity string) (bool, error)) matchHandler {
+	if matcher == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, inputEntity any, storageEntity any) (bool, error) {
+		input, ok := inputEntity.(T)
+		if !ok {
+			return false, errors.New("entity is not of type '%s'", nameOf[T](), DedupEntityTypeMismatchErrorCode)
+		}
+
+		stored, _ := storageEntity.(string)
+		return matcher(ctx, input, stored)
+	}
+}
+
+// TypedSerializer adapts a typed serializer to the untyped form accepted by NewDeduper
+func TypedSerializer[T any](serializer func(ctx context.Context, inputEntity T) (string, error)) serializeHandler {
+	if serializer == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, inputEntity any) (string, error) {
+		input, ok := inputEntity.(T)
+		if !ok {
+			return "", errors.New("entity is not of type '%s'", nameOf[T](), DedupEntityTypeMismatchErrorCode)
+		}
+
+		return serializer(ctx, input)
+	}
+}
+
+// Deduper returns the untyped Deduper backing this facade
+func (t *TypedDeduper[T]) Deduper() *Deduper {
+	return t.deduper
+}
+
+func (t *TypedDeduper[T]) Hash(ctx context.Context, entity T, strategy HashStrategy, isValue bool) ([]byte, error) {
+	return t.deduper.Hash(ctx, entity, strategy, isValue)
+}
+
+func (t *TypedDeduper[T]) IsDuplicate(ctx context.Context, entity T, strategy HashStrategy, storeIfNot ...time.Duration) (bool, error) {
+	return t.deduper.IsDuplicate(ctx, entity, strategy, storeIfNot...)
+}
+
+func (t *TypedDeduper[T]) IsValueDuplicate(ctx context.Context, entity T, strategy HashStrategy, storeIfNot ...time.Duration) (bool, error) {
+	return t.deduper.IsValueDuplicate(ctx, entity, strategy, storeIfNot...)
+}
+
+func (t *TypedDeduper[T]) IsDuplicateN(ctx context.Context, entity T, strategy HashStrategy, limit int64, window time.Duration) (Occurrences, error) {
+	return t.deduper.IsDuplicateN(ctx, entity, strategy, limit, window)
+}
+
+func (t *TypedDeduper[T]) IsNearDuplicate(ctx context.Context, entity T, strategy HashStrategy, storeIfNot ...time.Duration) (NearDuplicate, bool, error) {
+	return t.deduper.IsNearDuplicate(ctx, entity, strategy, storeIfNot...)
+}
+
+func (t *TypedDeduper[T]) FindSimilar(ctx context.Context, entity T, strategy HashStrategy) ([]NearDuplicate, error) {
+	return t.deduper.FindSimilar(ctx, entity, strategy)
+}
+
+func (t *TypedDeduper[T]) StoreFingerprint(ctx context.Context, entity T, strategy HashStrategy, expiration time.Duration) ([]byte, error) {
+	return t.deduper.StoreFingerprint(ctx, entity, strategy, expiration)
+}
+
+func (t *TypedDeduper[T]) Claim(ctx context.Context, entity T, strategy HashStrategy, expiration time.Duration) (bool, error) {
+	return t.deduper.Claim(ctx, entity, strategy, expiration)
+}
+
+func (t *TypedDeduper[T]) Key(ctx context.Context, entity T, strategy HashStrategy) ([]byte, error) {
+	return t.deduper.Key(ctx, entity, strategy)
+}
+
+func (t *TypedDeduper[T]) IsDuplicateBatch(ctx context.Context, entities []T, strategy HashStrategy, storeIfNot ...time.Duration) ([]bool, []error) {
+	return t.deduper.IsDuplicateBatch(ctx, toAny(entities), strategy, storeIfNot...)
+}
+
+func (t *TypedDeduper[T]) StoreBatch(ctx context.Context, entities []T, strategy HashStrategy, expiration time.Duration) ([][]byte, []error) {
+	return t.deduper.StoreBatch(ctx, toAny(entities), strategy, expiration)
+}
+
+func (t *TypedDeduper[T]) Store(ctx context.Context, entity T, strategy HashStrategy, expiration time.Duration) ([]byte, []byte, error) {
+	return t.deduper.Store(ctx, entity, strategy, expiration)
+}
+
+func (t *TypedDeduper[T]) StoreHash(ctx context.Context, hash []byte, expiration time.Duration) ([]byte, error) {
+	return t.deduper.StoreHash(ctx, hash, expiration)
+}
+
+func (t *TypedDeduper[T]) Forget(ctx context.Context, entity T, strategy HashStrategy) error {
+	return t.deduper.Forget(ctx, entity, strategy)
+}
+
+func (t *TypedDeduper[T]) ForgetHash(ctx context.Context, hash []byte) error {
+	return t.deduper.ForgetHash(ctx, hash)
+}
+
+func (t *TypedDeduper[T]) Begin(ctx context.Context, entity T, strategy HashStrategy, lease time.Duration) (bool, IdempotencyRecord, error) {
+	return t.deduper.Begin(ctx, entity, strategy, lease)
+}
+
+func (t *TypedDeduper[T]) Complete(ctx context.Context, entity T, strategy HashStrategy, expiration time.Duration, result []byte) error {
+	return t.deduper.Complete(ctx, entity, strategy, expiration, result)
+}
+
+func (t *TypedDeduper[T]) Status(ctx context.Context, entity T, strategy HashStrategy) (IdempotencyRecord, error) {
+	return t.deduper.Status(ctx, entity, strategy)
+}
+
+func (t *TypedDeduper[T]) StoreResult(ctx context.Context, entity T, strategy HashStrategy, result []byte, expiration time.Duration) ([]byte, error) {
+	return t.deduper.StoreResult(ctx, entity, strategy, result, expiration)
+}
+
+func (t *TypedDeduper[T]) GetResult(ctx context.Context, entity T, strategy HashStrategy) ([]byte, bool, error) {
+	return t.deduper.GetResult(ctx, entity, strategy)
+}
+
+func (t *TypedDeduper[T]) TTL(ctx context.Context, hash []byte) (time.Duration, error) {
+	return t.deduper.TTL(ctx, hash)
+}
+
+// Scoped returns a TypedDeduper with every call scoped to scope, see Deduper.Scoped
+func (t *TypedDeduper[T]) Scoped(scope string) *TypedDeduper[T] {
+	return &TypedDeduper[T]{deduper: t.deduper.Scoped(scope)}
+}
+
+func (t *TypedDeduper[T]) ScopeKeys(ctx context.Context, scope string, fn func(key []byte) error) error {
+	return t.deduper.ScopeKeys(ctx, scope, fn)
+}
+
+func (t *TypedDeduper[T]) PurgeScope(ctx context.Context, scope string) (int, error) {
+	return t.deduper.PurgeScope(ctx, scope)
+}
+
+func toAny[T any](entities []T) []any {
+	out := make([]any, len(entities))
+	for i, entity := range entities {
+		out[i] = entity
+	}
+	return out
+}
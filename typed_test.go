@@ -0,0 +1,96 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"hash"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedDeduper(t *testing.T) {
+	ctx := context.Background()
+
+	hashHandler := func(ctx context.Context, entity TestEntity) ([]byte, error) {
+		return []byte(entity.ID + ":" + entity.Name), nil
+	}
+
+	matcher := func(ctx context.Context, input TestEntity, stored string) (bool, error) {
+		var storedEntity TestEntity
+		if err := json.Unmarshal([]byte(stored), &storedEntity); err != nil {
+			return false, err
+		}
+		return input.ID == storedEntity.ID, nil
+	}
+
+	serializer := func(ctx context.Context, input TestEntity) (string, error) {
+		data, err := json.Marshal(input)
+		return string(data), err
+	}
+
+	t.Run("Full deduplication flow", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		deduper := NewTypedDeduper(hashHandler, storage, NewMockLogger(), func() hash.Hash { return sha1.New() }, matcher, serializer)
+		assert.NotNil(t, deduper)
+
+		entity := TestEntity{ID: "123", Name: "Test"}
+
+		isDuplicate, err := deduper.IsDuplicate(ctx, entity, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.False(t, isDuplicate)
+
+		hash, key, err := deduper.Store(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.Contains(t, string(key), "dedup:dedup.TestEntity:")
+
+		isDuplicate, err = deduper.IsDuplicate(ctx, entity, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		// The typed matcher receives the stored string
+		isDuplicate, err = deduper.IsValueDuplicate(ctx, entity, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		ttl, err := deduper.TTL(ctx, hash)
+		assert.NoError(t, err)
+		assert.True(t, ttl > 0 && ttl <= 10*time.Second)
+
+		claimed, err := deduper.Claim(ctx, TestEntity{ID: "456", Name: "Other"}, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+		assert.True(t, claimed)
+	})
+
+	t.Run("Untyped API keeps working", func(t *testing.T) {
+		storage := NewMemoryStorage(ctx, MemoryStorageConfig{SweepInterval: -1})
+		deduper := NewTypedDeduper(hashHandler, storage, NewMockLogger(), func() hash.Hash { return sha1.New() }, nil, serializer, "custom:")
+
+		entity := TestEntity{ID: "123", Name: "Test"}
+		_, _, err := deduper.Store(ctx, entity, DefaultHashStrategy(), 10*time.Second)
+		assert.NoError(t, err)
+
+		isDuplicate, err := deduper.Deduper().IsDuplicate(ctx, entity, DefaultHashStrategy())
+		assert.NoError(t, err)
+		assert.True(t, isDuplicate)
+
+		_, err = deduper.Deduper().IsDuplicate(ctx, "not a TestEntity", DefaultHashStrategy())
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "entity is not of type")
+	})
+
+	t.Run("Typed adapters reject wrong types", func(t *testing.T) {
+		_, err := TypedMatcher(matcher)(ctx, "not a TestEntity", "")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "entity is not of type")
+
+		_, err = TypedSerializer(serializer)(ctx, 42)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "entity is not of type")
+
+		assert.Nil(t, TypedMatcher[TestEntity](nil))
+		assert.Nil(t, TypedSerializer[TestEntity](nil))
+	})
+}